@@ -30,8 +30,8 @@ and usage of using your command. For example:
 Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		config.Prompt()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return config.Prompt()
 	},
 }
 
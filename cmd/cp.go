@@ -16,31 +16,63 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/JFenstermacher/awssh/pkg/ssh"
 	"github.com/spf13/cobra"
 )
 
 // cpCmd represents the cp command
 var cpCmd = &cobra.Command{
-	Use:   "cp",
+	Use:   "cp SOURCE DESTINATION",
 	Short: "SCP file to instance",
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Copies files to or from an EC2 instance using scp, or rsync when --rsync is passed.
+Exactly one of the paths must be remote, which is marked by prefixing it with ':'.
+The remote path may also be prefixed by an instance ID or Name tag to skip prompting.
+
+  awssh cp ./app.conf :/etc/app/app.conf
+  awssh cp -r web-1:/var/log/app ./logs
+
+Assuming a successful transfer, the instance and key selection will be saved so no future key prompting will occur.
+  `,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		flags := cmd.Flags()
 
+		if err := ssh.ValidateFlags(flags); err != nil {
+			return err
+		}
+
+		src, dst := ssh.ParseCopyPath(args[0]), ssh.ParseCopyPath(args[1])
+
+		if err := ssh.ValidateCopyPaths(src, dst); err != nil {
+			return err
+		}
+
+		defer ssh.WaitForRefresh()
+
 		cachepath := ssh.GetCachePath()
 		cache := ssh.NewKeyCache(cachepath.Path)
 
-		instance := ssh.PromptInstance(cmd.Flags())
+		instance, err := ssh.PromptInstance(flags, ssh.GetRemoteHost(src, dst))
+
+		if err != nil {
+			return err
+		}
+
+		key, eic, err := getKey(flags, instance, cache)
 
-		key, _ := flags.GetString("identityFile")
+		if err != nil {
+			return err
+		}
+
+		if err := ssh.Copy(flags, instance, key, src, dst); err != nil {
+			return err
+		}
 
-		if key == "" {
-			key = ssh.PromptKey(instance, cache)
+		if dryRun, _ := flags.GetBool("dryRun"); !dryRun && !eic {
+			return cache.Save(instance, key)
 		}
 
-		fmt.Println(instance, key)
+		return nil
 	},
 }
 
@@ -49,13 +81,22 @@ func init() {
 
 	cpCmd.Flags().String("profile", "", "AWS Profile")
 	cpCmd.Flags().String("region", "", "AWS Region")
+	cpCmd.Flags().StringSlice("profiles", []string{}, "list instances across multiple AWS Profiles")
+	cpCmd.Flags().StringSlice("regions", []string{}, "list instances across multiple AWS Regions")
+	cpCmd.Flags().Bool("all-regions", false, "list instances across all enabled AWS Regions")
+	cpCmd.Flags().Bool("refresh", false, "ignore the cached instance inventory and list instances again")
 	cpCmd.Flags().StringP("identityFile", "i", "", "identity file required for log into instance")
 	cpCmd.Flags().StringP("loginName", "l", "", "username to use while logging into instance")
 	cpCmd.Flags().StringSliceP("option", "o", []string{}, "SSH options")
+	cpCmd.Flags().StringArray("tag", []string{}, "only include instances with tag {key}={value}")
+	cpCmd.Flags().StringArray("filter", []string{}, "only include instances matching EC2 filter {name}={value}")
 
 	cpCmd.Flags().IntP("port", "p", 22, "SSH port")
 
+	cpCmd.Flags().BoolP("recursive", "r", false, "recursively copy entire directories")
+	cpCmd.Flags().Bool("rsync", false, "use rsync instead of scp for the transfer")
 	cpCmd.Flags().BoolP("dryRun", "d", false, "print command without running")
+	cpCmd.Flags().Bool("eic", false, "push an ephemeral key via EC2 Instance Connect instead of choosing a key")
 	cpCmd.Flags().Bool("ssm", false, "filters instance and use SSM to connect")
 	cpCmd.Flags().Bool("pub", false, "filters instances and use Public IP to connect")
 	cpCmd.Flags().Bool("priv", false, "filters instances and use Private IP to connect")
@@ -0,0 +1,62 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+
+	"github.com/AlecAivazis/survey/v2/terminal"
+	"github.com/JFenstermacher/awssh/pkg/config"
+	"github.com/JFenstermacher/awssh/pkg/finder"
+	"github.com/JFenstermacher/awssh/pkg/ssh"
+)
+
+const (
+	exitError       = 1
+	exitUsage       = 2
+	exitConfig      = 3
+	exitNoInstances = 4
+	exitNoKeys      = 5
+	exitNoTarget    = 6
+	exitInterrupt   = 130
+)
+
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{ssh.ErrUsage, exitUsage},
+	{config.ErrConfigMissing, exitConfig},
+	{config.ErrTunnelNotFound, exitUsage},
+	{ssh.ErrNoInstances, exitNoInstances},
+	{ssh.ErrNotRunning, exitNoInstances},
+	{ssh.ErrNoKeys, exitNoKeys},
+	{ssh.ErrNoTarget, exitNoTarget},
+	{ssh.ErrInterrupted, exitInterrupt},
+	{terminal.InterruptErr, exitInterrupt},
+	{finder.ErrAborted, exitInterrupt},
+}
+
+// getExitCode maps errors returned by commands to distinct exit codes, so scripts can tell failures apart.
+func getExitCode(err error) int {
+	for _, mapping := range exitCodes {
+		if errors.Is(err, mapping.err) {
+			return mapping.code
+		}
+	}
+
+	return exitError
+}
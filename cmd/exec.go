@@ -0,0 +1,235 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/ssh"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec [INSTANCE] -- COMMAND",
+	Short: "Run a command on one or more EC2 instances",
+	Long: `Runs a command on EC2 instances over ssh, the same way a login is made.
+The command follows --, or a local script is passed with --script and piped to a remote shell, with any arguments after -- passed to it.
+
+  awssh exec web-1 -- uptime
+  awssh exec --tag Team=platform -- sudo systemctl restart app
+  awssh exec --script deploy.sh -- v1.2.3
+
+When a selector matches several running instances, the command runs on all of them, otherwise instances are chosen from a prompt.
+With more than one instance, commands run in parallel, output is prefixed by each instance's label and a summary of exit codes is printed.
+Parallel runs can't answer prompts, so ssh runs with BatchMode=yes and an instance whose host key isn't known yet fails with 255,
+pass -o StrictHostKeyChecking=accept-new to trust new host keys.
+
+Instances without ssh access can be reached with --via ssm, which sends the command through SSM Run Command (AWS-RunShellScript)
+to instances whose SSM agent is online, whatever its version. The command runs as root rather than the login user.
+Exit codes match ssh, with 255 when the agent didn't pick the command up within SSM's delivery timeout or it didn't complete
+within --timeout. Commands that timed out or were interrupted with Ctrl-C are cancelled.
+  `,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if dash := cmd.ArgsLenAtDash(); dash > 1 || (dash < 0 && len(args) > 1) {
+			return fmt.Errorf("%w: only one instance may precede --", ssh.ErrUsage)
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+
+		if err := ssh.ValidateFlags(flags); err != nil {
+			return err
+		}
+
+		query, command := splitExecArgs(cmd, args)
+
+		remote, script, err := ssh.GetRemoteCommand(flags, command)
+
+		if err != nil {
+			return err
+		}
+
+		defer ssh.WaitForRefresh()
+
+		cachepath := ssh.GetCachePath()
+		cache := ssh.NewKeyCache(cachepath.Path)
+
+		instances, err := ssh.PromptInstances(flags, query)
+
+		if err != nil {
+			return err
+		}
+
+		if ssh.ViaSSM(flags) {
+			return execSSM(flags, instances, remote, script)
+		}
+
+		targets := []ssh.RemoteTarget{}
+
+		for idx := range instances {
+			instance := &instances[idx]
+
+			// Ephemeral keys expire quickly, so they're pushed by each target right before it connects
+			if ssh.UseEIC(flags) {
+				targets = append(targets, ssh.RemoteTarget{Instance: instance, EIC: true})
+				continue
+			}
+
+			key, _, err := getKey(flags, instance, cache)
+
+			if err != nil {
+				return err
+			}
+
+			targets = append(targets, ssh.RemoteTarget{Instance: instance, Key: key})
+		}
+
+		dryRun, _ := flags.GetBool("dryRun")
+
+		if len(targets) == 1 {
+			err := ssh.RunRemote(flags, targets[0], remote, script)
+
+			if !dryRun && !targets[0].EIC && ssh.ReachedRemote(err) {
+				if err := cache.Save(targets[0].Instance, targets[0].Key); err != nil {
+					return err
+				}
+			}
+
+			return err
+		}
+
+		concurrency, _ := flags.GetInt("concurrency")
+
+		results, err := ssh.RunRemoteParallel(flags, targets, remote, script, concurrency)
+
+		if err != nil || dryRun {
+			return err
+		}
+
+		for _, result := range results {
+			if result.Target.EIC || !result.ReachedRemote() {
+				continue
+			}
+
+			if err := cache.Save(result.Target.Instance, result.Target.Key); err != nil {
+				return err
+			}
+		}
+
+		return summarizeResults(results)
+	},
+}
+
+// execSSM sends the command through SSM Run Command, where no keys are involved.
+func execSSM(flags *pflag.FlagSet, instances []inst.Instance, remote []string, script []byte) error {
+	targets := []ssh.RemoteTarget{}
+
+	for idx := range instances {
+		targets = append(targets, ssh.RemoteTarget{Instance: &instances[idx]})
+	}
+
+	results, err := ssh.RunRemoteSSM(inst.DefaultClients, flags, targets, remote, script)
+
+	if err != nil || len(results) == 0 {
+		return err
+	}
+
+	// A single instance exits like ssh would, with the command's status
+	if len(results) == 1 {
+		if results[0].Err != nil {
+			return results[0].Err
+		}
+
+		if results[0].Code != 0 {
+			return &ssh.RemoteExitError{Code: results[0].Code}
+		}
+
+		return nil
+	}
+
+	return summarizeResults(results)
+}
+
+// summarizeResults prints the exit status table, failing when the command failed on any instance.
+func summarizeResults(results []ssh.RemoteResult) error {
+	ssh.PrintRemoteSummary(os.Stderr, results)
+
+	failed := 0
+
+	for _, result := range results {
+		if result.Failed() {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w on %d of %d instances", ssh.ErrRemoteFailed, failed, len(results))
+	}
+
+	return nil
+}
+
+// splitExecArgs separates the optional instance query from the command following --.
+func splitExecArgs(cmd *cobra.Command, args []string) (string, []string) {
+	dash := cmd.ArgsLenAtDash()
+
+	if dash < 0 {
+		dash = len(args)
+	}
+
+	query := ""
+
+	if dash > 0 {
+		query = args[0]
+	}
+
+	return query, args[dash:]
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	execCmd.Flags().String("profile", "", "AWS Profile")
+	execCmd.Flags().String("region", "", "AWS Region")
+	execCmd.Flags().StringSlice("profiles", []string{}, "list instances across multiple AWS Profiles")
+	execCmd.Flags().StringSlice("regions", []string{}, "list instances across multiple AWS Regions")
+	execCmd.Flags().Bool("all-regions", false, "list instances across all enabled AWS Regions")
+	execCmd.Flags().Bool("refresh", false, "ignore the cached instance inventory and list instances again")
+	execCmd.Flags().StringP("identityFile", "i", "", "identity file required for log into instance")
+	execCmd.Flags().StringP("loginName", "l", "", "username to use while logging into instance")
+	execCmd.Flags().StringSliceP("option", "o", []string{}, "SSH options")
+	execCmd.Flags().StringArray("tag", []string{}, "only include instances with tag {key}={value}")
+	execCmd.Flags().StringArray("filter", []string{}, "only include instances matching EC2 filter {name}={value}")
+
+	execCmd.Flags().IntP("port", "p", 22, "SSH port")
+
+	execCmd.Flags().String("script", "", "local script to run with the remote shell")
+	execCmd.Flags().IntP("concurrency", "c", 10, "maximum number of instances to run on at once")
+	execCmd.Flags().String("via", "ssh", "run the command over ssh or through SSM Run Command (ssm), which runs as root")
+	execCmd.Flags().Duration("timeout", 10*time.Minute, "with --via ssm, how long the command may take on each instance")
+	execCmd.Flags().BoolP("dryRun", "d", false, "print commands without running")
+	execCmd.Flags().Bool("eic", false, "push an ephemeral key via EC2 Instance Connect instead of choosing a key")
+	execCmd.Flags().Bool("ssm", false, "filters instance and use SSM to connect")
+	execCmd.Flags().Bool("pub", false, "filters instances and use Public IP to connect")
+	execCmd.Flags().Bool("priv", false, "filters instances and use Private IP to connect")
+}
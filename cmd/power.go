@@ -16,36 +16,49 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
-
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/ssh"
 	"github.com/spf13/cobra"
 )
 
 // powerCmd represents the power command
 var powerCmd = &cobra.Command{
-	Use:   "power",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("power called")
+	Use:   "power start|stop|reboot|hibernate",
+	Short: "Start, stop, reboot or hibernate an EC2 instance",
+	Long: `Changes the power state of an EC2 instance and waits until it reaches the resulting state.
+A reboot is only requested, as the instance stays running while it reboots.
+The instance is prompted in any state, unless --instance-id is passed.
+  `,
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: inst.PowerActions,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		action := inst.PowerAction(args[0])
+
+		if id, _ := flags.GetString("instance-id"); id != "" {
+			profile, _ := flags.GetString("profile")
+			region, _ := flags.GetString("region")
+
+			return ssh.Power(&inst.Instance{InstanceId: id, Profile: profile, Region: region}, action)
+		}
+
+		instance, err := ssh.PromptAnyInstance(flags)
+
+		if err != nil {
+			return err
+		}
+
+		return ssh.Power(instance, action)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(powerCmd)
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// powerCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// powerCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	powerCmd.Flags().String("profile", "", "AWS Profile")
+	powerCmd.Flags().String("region", "", "AWS Region")
+	powerCmd.Flags().StringSlice("profiles", []string{}, "list instances across multiple AWS Profiles")
+	powerCmd.Flags().StringSlice("regions", []string{}, "list instances across multiple AWS Regions")
+	powerCmd.Flags().Bool("all-regions", false, "list instances across all enabled AWS Regions")
+	powerCmd.Flags().String("instance-id", "", "instance to act on without prompting")
 }
@@ -0,0 +1,47 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+// proxyCmd is used as the ssh ProxyCommand when connecting via SSM without the AWS CLI
+var proxyCmd = &cobra.Command{
+	Use:    "proxy INSTANCE_ID PORT",
+	Short:  "Proxy an SSH connection through SSM Session Manager",
+	Hidden: true,
+	Args:   cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+
+		profile, _ := flags.GetString("profile")
+		region, _ := flags.GetString("region")
+
+		return ssh.RunSessionManagerPlugin(profile, region, args[0], inst.SSHSessionDocument, map[string]string{
+			"portNumber": args[1],
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(proxyCmd)
+
+	proxyCmd.Flags().String("profile", "", "AWS Profile")
+	proxyCmd.Flags().String("region", "", "AWS Region")
+}
@@ -16,58 +16,152 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
 	"github.com/JFenstermacher/awssh/pkg/ssh"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "awssh",
+	Use:   "awssh [INSTANCE]",
 	Short: "SSH into an EC2 instance",
 	Long: `Queries instances based on profile and region.
 The instances are prompted and rendered based on a configurable template string.
-Once an instance is chosen, the private key will either be matched based on prefix or a prompt will appear.
-The keys displayed are based on the configurable keys directory.
-
-Assuming a successful login, on logout the instance and key selection will be saved so no future key prompting will occur.
+Listings are cached per profile and region, stale entries are marked and refreshed in the background unless --refresh is passed.
+Instances can be narrowed by instance ID or Name tag, --tag and --filter. When exactly one instance matches, no prompt will appear.
+The login user is --loginName, the instance's ssh-user tag (UserTag in the config), a user matched from its AMI or DefaultUser.
+AMIs of Ubuntu, Debian, RHEL, CentOS, Fedora, Rocky, Bottlerocket, SUSE and Amazon Linux are known, others can be added:
+
+  UserRules:
+    - image: golden-base-*
+      owner: "111122223333"
+      user: deploy
+
+Once an instance is chosen, the private key is matched to its EC2 key pair by fingerprint, otherwise a prompt will appear.
+The keys matched and displayed are based on the configurable keys directory, along with the identities loaded in the agent at SSH_AUTH_SOCK.
+When an agent identity matches or is chosen, ssh authenticates with the agent and no -i is passed.
+With EC2 Instance Connect enabled, an ephemeral key is pushed to the instance instead and no key is prompted.
+
+The address is the first available in ConnectionOrder out of PUBLIC, PRIVATE, PUBLIC_DNS, PRIVATE_DNS, IPV6, ELASTIC_IP and SSM,
+falling back to secondary network interfaces. --pub, --priv and --ssm force one and only list instances that have it.
+--dryRun prints the command along with the rule that chose the address.
+
+Private addresses are reached through a bastion when one of the Bastions rules in the config matches the instance.
+The first matching rule applies, its bastion is found in the instance's profile and region and reached by public IP or SSM:
+
+  Bastions:
+    - match: [vpc-id=vpc-0abc, tag:Env=prod]
+      bastion: bastion-*
+      loginName: ec2-user
+
+Passing -o ProxyJump=none connects directly.
+
+Assuming a successful login, on logout the key selection will be saved for the instance and its key pair,
+so neither it nor other instances sharing the key pair in the same account and region will prompt again.
+awssh exits with the status of ssh, so remote command failures can be told apart from awssh errors.
   `,
-	Run: func(cmd *cobra.Command, args []string) {
+	Args:          cobra.MaximumNArgs(1),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		flags := cmd.Flags()
-		ssh.ValidateFlags(flags)
+
+		if err := ssh.ValidateFlags(flags); err != nil {
+			return err
+		}
+
+		defer ssh.WaitForRefresh()
 
 		cachepath := ssh.GetCachePath()
 		cache := ssh.NewKeyCache(cachepath.Path)
 
-		instance := ssh.PromptInstance(cmd.Flags())
+		query := ""
 
-		key, _ := flags.GetString("identityFile")
+		if len(args) > 0 {
+			query = args[0]
+		}
 
-		if key == "" {
-			key = ssh.PromptKey(instance, cache)
+		instance, err := ssh.PromptInstance(flags, query)
+
+		if err != nil {
+			return err
 		}
 
-		ssh.SSH(flags, instance, key)
+		key, eic, err := getKey(flags, instance, cache)
+
+		if err != nil {
+			return err
+		}
 
-		cache.Save(instance, key)
+		err = ssh.SSH(flags, instance, key)
+
+		// ssh exits 255 on its own errors, any other status came from the remote side so the key worked
+		if dryRun, _ := flags.GetBool("dryRun"); !dryRun && !eic && ssh.ReachedRemote(err) {
+			if err := cache.Save(instance, key); err != nil {
+				return err
+			}
+		}
+
+		return err
 	},
 }
 
+// getKey resolves the identity file, pushing an ephemeral key when EC2 Instance Connect is used.
+func getKey(flags *pflag.FlagSet, instance *inst.Instance, cache *ssh.KeyCache) (string, bool, error) {
+	if ssh.UseEIC(flags) {
+		key, err := ssh.PushEICKey(flags, instance)
+
+		return key, true, err
+	}
+
+	if key, _ := flags.GetString("identityFile"); key != "" {
+		return key, false, nil
+	}
+
+	key, err := ssh.PromptKey(inst.DefaultClients, instance, cache)
+
+	return key, false, err
+}
+
 func Execute() {
-	cobra.CheckErr(rootCmd.Execute())
+	if err := rootCmd.Execute(); err != nil {
+		// The child already reported its failure, only its status is passed on
+		if code, ok := ssh.ExitCode(err); ok {
+			os.Exit(code)
+		}
+
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(getExitCode(err))
+	}
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
 
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return fmt.Errorf("%w: %s", ssh.ErrUsage, err)
+	})
+
 	rootCmd.Flags().String("profile", "", "AWS Profile")
 	rootCmd.Flags().String("region", "", "AWS Region")
+	rootCmd.Flags().StringSlice("profiles", []string{}, "list instances across multiple AWS Profiles")
+	rootCmd.Flags().StringSlice("regions", []string{}, "list instances across multiple AWS Regions")
+	rootCmd.Flags().Bool("all-regions", false, "list instances across all enabled AWS Regions")
+	rootCmd.Flags().Bool("refresh", false, "ignore the cached instance inventory and list instances again")
 	rootCmd.Flags().StringP("identityFile", "i", "", "identity file required for log into instance")
 	rootCmd.Flags().StringP("loginName", "l", "", "username to use while logging into instance")
 	rootCmd.Flags().StringSliceP("option", "o", []string{}, "SSH options")
+	rootCmd.Flags().StringArray("tag", []string{}, "only include instances with tag {key}={value}")
+	rootCmd.Flags().StringArray("filter", []string{}, "only include instances matching EC2 filter {name}={value}")
 
 	rootCmd.Flags().IntP("port", "p", 22, "SSH port")
 
 	rootCmd.Flags().BoolP("dryRun", "d", false, "print command without running")
+	rootCmd.Flags().Bool("eic", false, "push an ephemeral key via EC2 Instance Connect instead of choosing a key")
 	rootCmd.Flags().Bool("ssm", false, "filters instance and use SSM to connect")
 	rootCmd.Flags().Bool("pub", false, "filters instances and use Public IP to connect")
 	rootCmd.Flags().Bool("priv", false, "filters instances and use Private IP to connect")
@@ -0,0 +1,113 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/JFenstermacher/awssh/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+// sshConfigCmd represents the ssh-config command
+var sshConfigCmd = &cobra.Command{
+	Use:   "ssh-config",
+	Short: "Manage an ssh config file for discovered instances",
+}
+
+// sshConfigGenerateCmd represents the ssh-config generate command
+var sshConfigGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Write a Host entry for every instance to ~/.awsshgo/ssh_config",
+	Long: `Writes a Host entry for every discovered instance to ~/.awsshgo/ssh_config, so plain ssh and editors can connect by alias.
+Aliases are rendered from the configurable HostAlias template, an alias taken by another instance gets the instance ID appended.
+HostName follows the connection order, with ProxyCommand for SSM and ProxyJump for configured bastions.
+IdentityFile is the key last used with the instance, or the one matching its key pair name.
+
+Running it again updates the entries of listed instances and keeps the others, so profiles and regions can be generated separately.
+Instances are always listed afresh, ignoring the inventory. A profile or region that fails to list keeps its entries.
+Terminated instances are removed. --prune also removes instances from the listed profiles and regions that no longer exist,
+instances that merely stopped matching --tag, --filter or the default filters are kept.
+
+Include the file at the top of ~/.ssh/config:
+
+  Include ~/.awsshgo/ssh_config
+  `,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+
+		if err := ssh.ValidateFlags(flags); err != nil {
+			return err
+		}
+
+		defer ssh.WaitForRefresh()
+
+		path := ssh.GetSSHConfigPath()
+
+		existing, err := ssh.ReadHostBlocks(path)
+
+		if err != nil {
+			return err
+		}
+
+		prune, _ := flags.GetBool("prune")
+
+		blocks, err := ssh.GenerateHostBlocks(flags, existing, prune)
+
+		if err != nil {
+			return err
+		}
+
+		if dryRun, _ := flags.GetBool("dryRun"); dryRun {
+			return ssh.WriteHostBlocks(os.Stdout, blocks)
+		}
+
+		if err := ssh.WriteSSHConfig(path, blocks); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Wrote %d hosts to %s\n", len(blocks), path)
+
+		if !ssh.HasSSHConfigInclude() {
+			fmt.Fprintf(os.Stderr, "Add \"Include %s\" at the top of ~/.ssh/config to use them\n", path)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sshConfigCmd)
+
+	sshConfigCmd.AddCommand(sshConfigGenerateCmd)
+
+	sshConfigGenerateCmd.Flags().String("profile", "", "AWS Profile")
+	sshConfigGenerateCmd.Flags().String("region", "", "AWS Region")
+	sshConfigGenerateCmd.Flags().StringSlice("profiles", []string{}, "list instances across multiple AWS Profiles")
+	sshConfigGenerateCmd.Flags().StringSlice("regions", []string{}, "list instances across multiple AWS Regions")
+	sshConfigGenerateCmd.Flags().Bool("all-regions", false, "list instances across all enabled AWS Regions")
+	sshConfigGenerateCmd.Flags().Bool("refresh", false, "ignore the cached instance inventory and list instances again")
+	sshConfigGenerateCmd.Flags().StringP("loginName", "l", "", "username to use while logging into instances")
+	sshConfigGenerateCmd.Flags().StringArray("tag", []string{}, "only include instances with tag {key}={value}")
+	sshConfigGenerateCmd.Flags().StringArray("filter", []string{}, "only include instances matching EC2 filter {name}={value}")
+	sshConfigGenerateCmd.Flags().Bool("prune", false, "remove instances from the listed profiles and regions that no longer exist")
+	sshConfigGenerateCmd.Flags().BoolP("dryRun", "d", false, "print the config without writing it")
+	sshConfigGenerateCmd.Flags().Bool("ssm", false, "filters instances and use SSM to connect")
+	sshConfigGenerateCmd.Flags().Bool("pub", false, "filters instances and use Public IP to connect")
+	sshConfigGenerateCmd.Flags().Bool("priv", false, "filters instances and use Private IP to connect")
+}
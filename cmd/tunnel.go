@@ -0,0 +1,354 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	"github.com/JFenstermacher/awssh/pkg/ssh"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// tunnelCmd represents the tunnel command
+var tunnelCmd = &cobra.Command{
+	Use:   "tunnel [INSTANCE]",
+	Short: "Forward ports through an EC2 instance",
+	Long: `Forwards ports through an EC2 instance used as a bastion, staying in the foreground until interrupted.
+--local is always the endpoint on this machine and --remote the endpoint reached through the instance.
+
+  awssh tunnel --local 5432 --remote db.internal:5432     # -L, local port defaults to the remote port
+  awssh tunnel --reverse --remote 8080 --local 3000       # -R, port 8080 on the instance to local port 3000
+  awssh tunnel --dynamic --local 1080                     # -D, SOCKS proxy
+
+A local port of 0 picks a free port. The bound local address is printed once it accepts connections.
+When the instance is reached through SSM, local forwards use an AWS-StartPortForwardingSessionToRemoteHost session and no key is needed,
+other forwards run ssh through the SSM proxy.
+
+Forwards used often can be named in the Tunnels section of the config and run in the background with up, ls and down:
+
+  Tunnels:
+    db:
+      bastion: bastion-*
+      profile: prod
+      local: "5432"
+      remote: db.internal:5432
+  `,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+
+		if err := ssh.ValidateFlags(flags); err != nil {
+			return err
+		}
+
+		forward, err := ssh.NewForward(flags)
+
+		if err != nil {
+			return err
+		}
+
+		defer ssh.WaitForRefresh()
+
+		cachepath := ssh.GetCachePath()
+		cache := ssh.NewKeyCache(cachepath.Path)
+
+		query := ""
+
+		if len(args) > 0 {
+			query = args[0]
+		}
+
+		instance, err := ssh.PromptInstance(flags, query)
+
+		if err != nil {
+			return err
+		}
+
+		useSSM, err := ssh.UseSSMForwarding(flags, instance, forward)
+
+		if err != nil {
+			return err
+		}
+
+		if useSSM {
+			return ssh.TunnelSSM(flags, instance, forward)
+		}
+
+		key, eic, err := getKey(flags, instance, cache)
+
+		if err != nil {
+			return err
+		}
+
+		err = ssh.Tunnel(flags, instance, key, forward)
+
+		if dryRun, _ := flags.GetBool("dryRun"); !dryRun && !eic && ssh.ReachedRemote(err) {
+			if err := cache.Save(instance, key); err != nil {
+				return err
+			}
+		}
+
+		return err
+	},
+}
+
+// tunnelUpCmd represents the tunnel up command
+var tunnelUpCmd = &cobra.Command{
+	Use:   "up NAME",
+	Short: "Start a named tunnel in the background",
+	Long: `Starts a tunnel from the Tunnels config in the background, reconnecting whenever the connection drops.
+The bastion and key are chosen up front, flags given here override the configured values.
+Its pid and log are kept in ~/.awsshgo/tunnels.
+  `,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		name := strings.ToLower(args[0])
+
+		tunnel, err := config.GetTunnel(name)
+
+		if err != nil {
+			return err
+		}
+
+		if status := ssh.GetTunnelStatus(name); status.Alive {
+			return fmt.Errorf("%w: tunnel %s is already running with pid %d", ssh.ErrUsage, name, status.Pid)
+		}
+
+		if err := applyTunnelConfig(flags, tunnel); err != nil {
+			return err
+		}
+
+		if err := ssh.ValidateFlags(flags); err != nil {
+			return err
+		}
+
+		forward, err := ssh.NewForward(flags)
+
+		if err != nil {
+			return err
+		}
+
+		defer ssh.WaitForRefresh()
+
+		instance, err := ssh.PromptInstance(flags, tunnel.Bastion)
+
+		if err != nil {
+			return err
+		}
+
+		runArgs := []string{"tunnel", "run", name, instance.InstanceId}
+
+		if instance.Profile != "" {
+			runArgs = append(runArgs, "--profile", instance.Profile)
+		}
+
+		runArgs = append(runArgs, "--region", instance.Region)
+		runArgs = append(runArgs, forward.Args()...)
+		runArgs = append(runArgs, getChangedFlags(flags, "loginName", "port", "option", "ssm", "pub", "priv")...)
+
+		useSSM, err := ssh.UseSSMForwarding(flags, instance, forward)
+
+		if err != nil {
+			return err
+		}
+
+		if !useSSM {
+			cachepath := ssh.GetCachePath()
+
+			key, eic, err := getKey(flags, instance, ssh.NewKeyCache(cachepath.Path))
+
+			if err != nil {
+				return err
+			}
+
+			if eic {
+				runArgs = append(runArgs, "--eic")
+			} else {
+				runArgs = append(runArgs, "--identityFile", key)
+			}
+		}
+
+		if dryRun, _ := flags.GetBool("dryRun"); dryRun {
+			fmt.Println("awssh", strings.Join(runArgs, " "))
+
+			return nil
+		}
+
+		pid, err := ssh.StartTunnel(name, runArgs)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Tunnel %s forwarding %s through %s is running with pid %d\n", name, forward, instance.InstanceId, pid)
+		fmt.Fprintf(os.Stderr, "Logs: %s\n", ssh.GetTunnelLogPath(name))
+
+		return nil
+	},
+}
+
+// tunnelLsCmd represents the tunnel ls command
+var tunnelLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List named tunnels and whether they're running",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tunnels, err := config.GetTunnels()
+
+		if err != nil {
+			return err
+		}
+
+		return ssh.PrintTunnels(os.Stdout, tunnels)
+	},
+}
+
+// tunnelDownCmd represents the tunnel down command
+var tunnelDownCmd = &cobra.Command{
+	Use:   "down NAME",
+	Short: "Stop a named tunnel running in the background",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := strings.ToLower(args[0])
+
+		if err := ssh.StopTunnel(name); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Tunnel %s stopped\n", name)
+
+		return nil
+	},
+}
+
+// tunnelRunCmd is started by tunnel up and supervises the tunnel in the background
+var tunnelRunCmd = &cobra.Command{
+	Use:    "run NAME INSTANCE_ID",
+	Hidden: true,
+	Args:   cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+
+		if err := ssh.ValidateFlags(flags); err != nil {
+			return err
+		}
+
+		forward, err := ssh.NewForward(flags)
+
+		if err != nil {
+			return err
+		}
+
+		return ssh.SuperviseTunnel(flags, args[0], args[1], forward)
+	},
+}
+
+// applyTunnelConfig fills in flags from a named tunnel, leaving the ones given on the command line.
+func applyTunnelConfig(flags *pflag.FlagSet, tunnel config.TunnelConfig) error {
+	values := map[string]string{
+		"profile": tunnel.Profile,
+		"region":  tunnel.Region,
+		"local":   tunnel.Local,
+		"remote":  tunnel.Remote,
+	}
+
+	for name, value := range values {
+		if value == "" || flags.Changed(name) {
+			continue
+		}
+
+		if err := flags.Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	for _, filter := range tunnel.Filters {
+		if err := flags.Set("filter", filter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getChangedFlags renders the given flags as arguments when they were set.
+func getChangedFlags(flags *pflag.FlagSet, names ...string) []string {
+	args := []string{}
+
+	for _, name := range names {
+		flag := flags.Lookup(name)
+
+		if flag == nil || !flag.Changed {
+			continue
+		}
+
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			for _, value := range slice.GetSlice() {
+				args = append(args, "--"+name, value)
+			}
+
+			continue
+		}
+
+		args = append(args, fmt.Sprintf("--%s=%s", name, flag.Value.String()))
+	}
+
+	return args
+}
+
+func init() {
+	rootCmd.AddCommand(tunnelCmd)
+
+	tunnelCmd.AddCommand(tunnelUpCmd)
+	tunnelCmd.AddCommand(tunnelLsCmd)
+	tunnelCmd.AddCommand(tunnelDownCmd)
+	tunnelCmd.AddCommand(tunnelRunCmd)
+
+	addTunnelFlags(tunnelCmd)
+	addTunnelFlags(tunnelUpCmd)
+	addTunnelFlags(tunnelRunCmd)
+}
+
+func addTunnelFlags(cmd *cobra.Command) {
+	cmd.Flags().String("profile", "", "AWS Profile")
+	cmd.Flags().String("region", "", "AWS Region")
+	cmd.Flags().StringSlice("profiles", []string{}, "list instances across multiple AWS Profiles")
+	cmd.Flags().StringSlice("regions", []string{}, "list instances across multiple AWS Regions")
+	cmd.Flags().Bool("all-regions", false, "list instances across all enabled AWS Regions")
+	cmd.Flags().Bool("refresh", false, "ignore the cached instance inventory and list instances again")
+	cmd.Flags().StringP("identityFile", "i", "", "identity file required for log into instance")
+	cmd.Flags().StringP("loginName", "l", "", "username to use while logging into instance")
+	cmd.Flags().StringSliceP("option", "o", []string{}, "SSH options")
+	cmd.Flags().StringArray("tag", []string{}, "only include instances with tag {key}={value}")
+	cmd.Flags().StringArray("filter", []string{}, "only include instances matching EC2 filter {name}={value}")
+
+	cmd.Flags().IntP("port", "p", 22, "SSH port")
+
+	cmd.Flags().String("local", "", "local [address:]port to listen on, or destination with --reverse")
+	cmd.Flags().String("remote", "", "[host:]port reached through the instance, or port to listen on with --reverse")
+	cmd.Flags().Bool("reverse", false, "forward a port on the instance back to this machine (-R)")
+	cmd.Flags().Bool("dynamic", false, "run a SOCKS proxy on the local port (-D)")
+	cmd.Flags().BoolP("dryRun", "d", false, "print command without running")
+	cmd.Flags().Bool("eic", false, "push an ephemeral key via EC2 Instance Connect instead of choosing a key")
+	cmd.Flags().Bool("ssm", false, "filters instance and use SSM to connect")
+	cmd.Flags().Bool("pub", false, "filters instances and use Public IP to connect")
+	cmd.Flags().Bool("priv", false, "filters instances and use Private IP to connect")
+}
@@ -2,23 +2,65 @@ package config
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Configuration struct {
 	BaseCommand     string
+	Bastions        []BastionRule
 	ConnectionOrder []string
+	DefaultFilters  []string
 	DefaultLogin    string
+	EICEnabled      bool
+	HostAlias       string
+	InventoryTTL    string
 	KeysDirectory   string
+	Picker          string
 	SSMEnabled      bool
 	TemplateString  string
+	Tunnels         map[string]TunnelConfig
+	UserRules       []UserRule
+	UserTag         string
 }
 
+// TunnelConfig is a named forward through a bastion, chosen by ID or Name tag and filters.
+type TunnelConfig struct {
+	Bastion string
+	Filters []string
+	Profile string
+	Region  string
+	Local   string
+	Remote  string
+}
+
+// BastionRule routes connections to private addresses through a bastion. Match holds filters on the target,
+// such as vpc-id, subnet-id or tag:{key}, an empty Match applies to every instance.
+// Bastion and Filters select the bastion by ID or Name tag and filters.
+type BastionRule struct {
+	Match     []string
+	Bastion   string
+	Filters   []string
+	LoginName string
+}
+
+// UserRule maps AMIs to a login user. Image matches the AMI name and Owner its owner ID or alias,
+// both may use wildcards and an empty field matches any AMI.
+type UserRule struct {
+	Image string
+	Owner string
+	User  string
+}
+
+// Connections are the ways an instance can be reached, tried in the configured ConnectionOrder.
+var Connections = []string{"PUBLIC", "PRIVATE", "PUBLIC_DNS", "PRIVATE_DNS", "IPV6", "ELASTIC_IP", "SSM"}
+
 type ConfigPath struct {
 	Dir  string
 	Ext  string
@@ -45,10 +87,16 @@ func SetDefaults(reset bool) {
 	defaults := map[string]interface{}{
 		"BaseFlags":       "",
 		"ConnectionOrder": []string{"PUBLIC", "PRIVATE"},
+		"DefaultFilters":  []string{},
 		"DefaultUser":     "ec2-user",
+		"EICEnabled":      false,
+		"HostAlias":       "{{ or .Tags.Name .InstanceId }}",
+		"InventoryTTL":    "10m",
 		"KeysDirectory":   filepath.Join(home, ".ssh"),
+		"Picker":          "select",
 		"SSMEnabled":      false,
 		"TemplateString":  "{{ .Tags.Name }} [{{ .InstanceId }}]",
+		"UserTag":         "ssh-user",
 	}
 
 	for key, value := range defaults {
@@ -77,14 +125,12 @@ func LoadConfig() {
 	SetDefaults(false)
 }
 
-func WriteConfig() {
+func WriteConfig() error {
 	configpath := GetConfigPath()
 
 	os.Mkdir(configpath.Dir, 0755)
 
-	if err := viper.WriteConfigAs(configpath.Path); err != nil {
-		log.Fatal(err)
-	}
+	return viper.WriteConfigAs(configpath.Path)
 }
 
 func GetBaseFlags() string {
@@ -93,34 +139,73 @@ func GetBaseFlags() string {
 	return flags
 }
 
-func GetConnectionOrder() []string {
+// GetBastions returns the bastion rules in order, the first matching rule applies.
+func GetBastions() ([]BastionRule, error) {
+	rules := []BastionRule{}
+
+	if err := viper.UnmarshalKey("Bastions", &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func GetConnectionOrder() ([]string, error) {
 	connections := viper.GetStringSlice("ConnectionOrder")
 
 	if len(connections) == 0 {
-		log.Fatal("No configuration found for [ConnectionOrder]")
+		return nil, missingError("ConnectionOrder")
 	}
 
-	return connections
+	return connections, nil
 }
 
-func GetDefaultUser() string {
+func GetDefaultFilters() []string {
+	return viper.GetStringSlice("DefaultFilters")
+}
+
+func GetDefaultUser() (string, error) {
 	user := viper.GetString("DefaultUser")
 
 	if user == "" {
-		log.Fatal("No configuration found for [DefaultUser]")
+		return "", missingError("DefaultUser")
 	}
 
-	return user
+	return user, nil
+}
+
+func GetEICEnabled() bool {
+	return viper.GetBool("EICEnabled")
 }
 
-func GetKeysDirectory() string {
+// GetHostAlias is the template rendering Host aliases in the generated ssh config.
+func GetHostAlias() (string, error) {
+	alias := viper.GetString("HostAlias")
+
+	if alias == "" {
+		return "", missingError("HostAlias")
+	}
+
+	return alias, nil
+}
+
+func GetInventoryTTL() time.Duration {
+	return viper.GetDuration("InventoryTTL")
+}
+
+func GetKeysDirectory() (string, error) {
 	dir := viper.GetString("KeysDirectory")
 
 	if dir == "" {
-		log.Fatal("No configuration found for [KeysDirectory]")
+		return "", missingError("KeysDirectory")
 	}
 
-	return dir
+	return dir, nil
+}
+
+// GetPicker is either "select" for a simple prompt, the default, or "fuzzy" for the full-screen finder.
+func GetPicker() string {
+	return viper.GetString("Picker")
 }
 
 func GetSSMEnabled() bool {
@@ -139,12 +224,72 @@ func IsSSMPossible() bool {
 	return true
 }
 
-func GetTemplateString() string {
+// GetTunnels returns the named tunnels, keyed by lowercase name as viper keys are case insensitive.
+func GetTunnels() (map[string]TunnelConfig, error) {
+	tunnels := map[string]TunnelConfig{}
+
+	if err := viper.UnmarshalKey("Tunnels", &tunnels); err != nil {
+		return nil, err
+	}
+
+	return tunnels, nil
+}
+
+// tunnelNotFoundError lists the configured names, as an unknown name is most likely a typo.
+func tunnelNotFoundError(name string, tunnels map[string]TunnelConfig) error {
+	if len(tunnels) == 0 {
+		return fmt.Errorf("%w: [%s], no Tunnels are configured", ErrTunnelNotFound, name)
+	}
+
+	names := []string{}
+
+	for configured := range tunnels {
+		names = append(names, configured)
+	}
+
+	sort.Strings(names)
+
+	return fmt.Errorf("%w: [%s], configured tunnels are %s", ErrTunnelNotFound, name, strings.Join(names, ", "))
+}
+
+func GetTunnel(name string) (TunnelConfig, error) {
+	tunnels, err := GetTunnels()
+
+	if err != nil {
+		return TunnelConfig{}, err
+	}
+
+	tunnel, found := tunnels[strings.ToLower(name)]
+
+	if !found {
+		return TunnelConfig{}, tunnelNotFoundError(name, tunnels)
+	}
+
+	return tunnel, nil
+}
+
+// GetUserRules returns the configured AMI rules, tried before the built-in ones.
+func GetUserRules() ([]UserRule, error) {
+	rules := []UserRule{}
+
+	if err := viper.UnmarshalKey("UserRules", &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// GetUserTag is the tag naming an instance's login user, empty to ignore tags.
+func GetUserTag() string {
+	return viper.GetString("UserTag")
+}
+
+func GetTemplateString() (string, error) {
 	template := viper.GetString("TemplateString")
 
 	if template == "" {
-		log.Fatal("No configuration found for [TemplateString]")
+		return "", missingError("TemplateString")
 	}
 
-	return template
+	return template, nil
 }
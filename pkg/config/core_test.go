@@ -0,0 +1,74 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestGettersReturnConfigMissing(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	getters := map[string]func() error{
+		"ConnectionOrder": func() error { _, err := GetConnectionOrder(); return err },
+		"DefaultUser":     func() error { _, err := GetDefaultUser(); return err },
+		"KeysDirectory":   func() error { _, err := GetKeysDirectory(); return err },
+		"TemplateString":  func() error { _, err := GetTemplateString(); return err },
+		"HostAlias":       func() error { _, err := GetHostAlias(); return err },
+	}
+
+	for key, getter := range getters {
+		if err := getter(); !errors.Is(err, ErrConfigMissing) {
+			t.Errorf("expected ErrConfigMissing for [%s], got %v", key, err)
+		}
+	}
+
+	SetDefaults(false)
+
+	for key, getter := range getters {
+		if err := getter(); err != nil {
+			t.Errorf("unexpected error for [%s] with defaults: %v", key, err)
+		}
+	}
+}
+
+func TestGetTunnel(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.SetConfigType("yaml")
+
+	config := []byte(`
+Tunnels:
+  Postgres:
+    Bastion: bastion-*
+    Filters: [tag:Env=prod]
+    Local: "15432"
+    Remote: db.internal:5432
+`)
+
+	if err := viper.ReadConfig(bytes.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	tunnel, err := GetTunnel("Postgres")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := TunnelConfig{Bastion: "bastion-*", Filters: []string{"tag:Env=prod"}, Local: "15432", Remote: "db.internal:5432"}
+
+	if !reflect.DeepEqual(tunnel, expected) {
+		t.Errorf("GetTunnel() = %+v, expected %+v", tunnel, expected)
+	}
+
+	if _, err := GetTunnel("redis"); !errors.Is(err, ErrTunnelNotFound) || !strings.Contains(err.Error(), "configured tunnels are postgres") {
+		t.Errorf("expected ErrTunnelNotFound listing postgres for unknown tunnel, got %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrConfigMissing is returned when a required configuration value is empty.
+var ErrConfigMissing = errors.New("No configuration found")
+
+// ErrTunnelNotFound is returned when no tunnel is configured under a name.
+var ErrTunnelNotFound = errors.New("Tunnel not found")
+
+func missingError(key string) error {
+	return fmt.Errorf("%w for [%s], reinitialize with awssh config", ErrConfigMissing, key)
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"errors"
-	"log"
+	"fmt"
+	"strings"
+	"time"
 
 	"text/template"
 
@@ -10,7 +12,7 @@ import (
 	"github.com/spf13/viper"
 )
 
-func getPromptKeys(m map[string]func()) []string {
+func getPromptKeys(m map[string]func() error) []string {
 	keys := []string{}
 
 	for key := range m {
@@ -20,14 +22,20 @@ func getPromptKeys(m map[string]func()) []string {
 	return keys
 }
 
-func Prompt() {
-	choices := map[string]func(){
-		"Base Command Flags": promptBaseFlags,
-		"Default EC2 User":   promptDefaultUser,
-		"SSH Keys Directory": promptKeysDirectory,
-		"Connection Order":   promptConnectionOrder,
-		"Template String":    promptTemplate,
-		"Reset Defaults":     resetDefaults,
+func Prompt() error {
+	choices := map[string]func() error{
+		"Base Command Flags":          promptBaseFlags,
+		"Default EC2 User":            promptDefaultUser,
+		"SSH Keys Directory":          promptKeysDirectory,
+		"Connection Order":            promptConnectionOrder,
+		"Default Instance Filters":    promptDefaultFilters,
+		"Instance Picker":             promptPicker,
+		"Inventory Cache TTL":         promptInventoryTTL,
+		"Toggle EC2 Instance Connect": promptEIC,
+		"Template String":             promptTemplate,
+		"SSH Config Host Alias":       promptHostAlias,
+		"Login User Tag":              promptUserTag,
+		"Reset Defaults":              resetDefaults,
 	}
 
 	if IsSSMPossible() {
@@ -45,16 +53,20 @@ func Prompt() {
 		choice := ""
 
 		if err := survey.AskOne(prompt, &choice); err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		choices[choice]()
+		if err := choices[choice](); err != nil {
+			return err
+		}
 
-		WriteConfig()
+		if err := WriteConfig(); err != nil {
+			return err
+		}
 	}
 }
 
-func promptBaseFlags() {
+func promptBaseFlags() error {
 	prompt := &survey.Input{
 		Message: "Specify Base Command Flags",
 		Default: GetBaseFlags(),
@@ -64,42 +76,71 @@ func promptBaseFlags() {
 	value := ""
 
 	if err := survey.AskOne(prompt, &value); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	viper.Set("BaseFlags", value)
+
+	return nil
 }
 
-func promptDefaultUser() {
+func promptDefaultUser() error {
+	// A missing value is what this prompt fixes, so it only leaves the default blank
+	user, _ := GetDefaultUser()
+
 	prompt := &survey.Input{
 		Message: "Specify Default EC2 User",
-		Default: GetDefaultUser(),
-		Help:    "Default user that will used to log into instance",
+		Default: user,
+		Help:    "Default user that will used to log into instance, when neither its user tag nor its AMI tells",
 	}
 
 	value := ""
 
 	if err := survey.AskOne(prompt, &value, survey.WithValidator(survey.Required)); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	viper.Set("DefaultUser", value)
+
+	return nil
 }
 
-func promptKeysDirectory() {
+func promptUserTag() error {
+	prompt := &survey.Input{
+		Message: "Specify Login User Tag",
+		Default: GetUserTag(),
+		Help:    "Instances tagged with this key are logged into as the tag's value, leave empty to ignore tags",
+	}
+
+	value := ""
+
+	if err := survey.AskOne(prompt, &value); err != nil {
+		return err
+	}
+
+	viper.Set("UserTag", value)
+
+	return nil
+}
+
+func promptKeysDirectory() error {
+	dir, _ := GetKeysDirectory()
+
 	prompt := &survey.Input{
 		Message: "Specify SSH Keys Directory",
-		Default: GetKeysDirectory(),
+		Default: dir,
 		Help:    "The directory where SSH keys are held",
 	}
 
 	value := ""
 
 	if err := survey.AskOne(prompt, &value); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	viper.Set("KeysDirectory", value)
+
+	return nil
 }
 
 func filterConns(conns []string, remove string) []string {
@@ -114,34 +155,164 @@ func filterConns(conns []string, remove string) []string {
 	return filtered
 }
 
-func promptConnectionOrder() {
-	conns := GetConnectionOrder()
-	prompt := &survey.Select{
-		Message: "Specify connection order",
-		Options: conns,
-		Help:    "Instances can be connected by either public ip, private ip or instance-id (SSM proxying).\nSpecify the order in which those options will be selected.",
+// getOrderOptions lists the current order first, followed by the remaining connections.
+func getOrderOptions(current []string) []string {
+	options := append([]string{}, current...)
+
+	for _, conn := range Connections {
+		if conn == "SSM" && !GetSSMEnabled() {
+			continue
+		}
+
+		if !containsConn(options, conn) {
+			options = append(options, conn)
+		}
 	}
 
+	return options
+}
+
+func containsConn(conns []string, conn string) bool {
+	for _, c := range conns {
+		if c == conn {
+			return true
+		}
+	}
+
+	return false
+}
+
+func promptConnectionOrder() error {
+	// Like the other prompts, a missing order only leaves nothing preselected
+	current, _ := GetConnectionOrder()
+
+	conns := getOrderOptions(current)
+
+	const done = "(done)"
+
 	res := []string{}
-	for len(conns) > 1 {
+	for len(conns) > 0 {
+		options := conns
+
+		if len(res) > 0 {
+			options = append([]string{done}, conns...)
+		}
+
+		prompt := &survey.Select{
+			Message: fmt.Sprintf("Choose connection #%d", len(res)+1),
+			Options: options,
+			Help:    "Instances can be connected by public or private IP, DNS name, IPv6, Elastic IP or instance-id (SSM proxying).\nChoose the order in which those options will be tried, secondary network interfaces are used when the primary has no address.",
+		}
+
 		value := ""
 
 		if err := survey.AskOne(prompt, &value); err != nil {
-			log.Fatal(err)
+			return err
+		}
+
+		if value == done {
+			break
 		}
 
 		res = append(res, value)
 		conns = filterConns(conns, value)
+	}
+
+	viper.Set("ConnectionOrder", res)
 
-		prompt.Options = conns
+	return nil
+}
+
+func promptDefaultFilters() error {
+	prompt := &survey.Input{
+		Message: "Specify Default Instance Filters",
+		Default: strings.Join(GetDefaultFilters(), ","),
+		Help:    "Comma separated EC2 filters applied when listing instances. Example: instance-state-name=running,tag:Team=platform",
 	}
 
-	res = append(res, conns...)
+	value := ""
+
+	validator := func(val interface{}) error {
+		str, _ := val.(string)
 
-	viper.Set("ConnectionOrder", res)
+		for _, filter := range splitFilters(str) {
+			if !strings.Contains(filter, "=") || strings.HasPrefix(filter, "=") {
+				return fmt.Errorf("Filters must be in form {name}={value}: [%s] failed", filter)
+			}
+		}
+
+		return nil
+	}
+
+	if err := survey.AskOne(prompt, &value, survey.WithValidator(validator)); err != nil {
+		return err
+	}
+
+	viper.Set("DefaultFilters", splitFilters(value))
+
+	return nil
+}
+
+func promptPicker() error {
+	prompt := &survey.Select{
+		Message: "Choose instance picker",
+		Options: []string{"select", "fuzzy"},
+		Default: GetPicker(),
+		Help:    "select uses a simple list prompt, fuzzy opens a full-screen finder with a preview pane",
+	}
+
+	value := ""
+
+	if err := survey.AskOne(prompt, &value); err != nil {
+		return err
+	}
+
+	viper.Set("Picker", value)
+
+	return nil
+}
+
+func promptInventoryTTL() error {
+	prompt := &survey.Input{
+		Message: "Specify Inventory Cache TTL",
+		Default: GetInventoryTTL().String(),
+		Help:    "Cached instance listings older than this are marked stale and refreshed in the background. Example: 10m",
+	}
+
+	value := ""
+
+	validator := func(val interface{}) error {
+		str, _ := val.(string)
+
+		if _, err := time.ParseDuration(str); err != nil {
+			return errors.New("TTL must be a duration such as 30s, 10m or 1h")
+		}
+
+		return nil
+	}
+
+	if err := survey.AskOne(prompt, &value, survey.WithValidator(validator)); err != nil {
+		return err
+	}
+
+	viper.Set("InventoryTTL", value)
+
+	return nil
 }
 
-func promptSSM() {
+func splitFilters(value string) []string {
+	filters := []string{}
+
+	for _, filter := range strings.Split(value, ",") {
+		if filter = strings.TrimSpace(filter); filter != "" {
+			filters = append(filters, filter)
+		}
+	}
+
+	return filters
+}
+
+func promptSSM() error {
 	enabled := GetSSMEnabled()
 
 	message := "Enable Connecting via SSM"
@@ -157,14 +328,18 @@ func promptSSM() {
 	value := false
 
 	if err := survey.AskOne(prompt, &value); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	if !value {
-		return
+		return nil
 	}
 
-	conns := GetConnectionOrder()
+	conns, err := GetConnectionOrder()
+
+	if err != nil {
+		return err
+	}
 
 	if enabled {
 		newConns := []string{}
@@ -176,20 +351,46 @@ func promptSSM() {
 		}
 
 		conns = newConns
-	} else {
-		if len(conns) == 2 {
-			conns = append(conns, "SSM")
-		}
+	} else if !containsConn(conns, "SSM") {
+		conns = append(conns, "SSM")
 	}
 
-	viper.Set("SSMEnabled", value)
+	viper.Set("SSMEnabled", !enabled)
 	viper.Set("ConnectionOrder", conns)
+
+	return nil
 }
 
-func promptTemplate() {
+func promptEIC() error {
+	message := "Enable pushing ephemeral keys via EC2 Instance Connect"
+
+	if GetEICEnabled() {
+		message = "Disable pushing ephemeral keys via EC2 Instance Connect"
+	}
+
+	prompt := &survey.Confirm{
+		Message: message,
+	}
+
+	value := false
+
+	if err := survey.AskOne(prompt, &value); err != nil {
+		return err
+	}
+
+	if value {
+		viper.Set("EICEnabled", !GetEICEnabled())
+	}
+
+	return nil
+}
+
+func promptTemplate() error {
+	templateDefault, _ := GetTemplateString()
+
 	prompt := &survey.Input{
 		Message: "Provide Instance Rendering Template",
-		Default: GetTemplateString(),
+		Default: templateDefault,
 	}
 
 	templateString := ""
@@ -211,13 +412,45 @@ func promptTemplate() {
 	}
 
 	if err := survey.AskOne(prompt, &templateString, survey.WithValidator(validator)); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	viper.Set("TemplateString", templateString)
+
+	return nil
 }
 
-func resetDefaults() {
+func promptHostAlias() error {
+	aliasDefault, _ := GetHostAlias()
+
+	prompt := &survey.Input{
+		Message: "Provide SSH Config Host Alias Template",
+		Default: aliasDefault,
+		Help:    "Renders the Host alias of each instance in the file written by awssh ssh-config generate. Example: {{ .Tags.Name }}",
+	}
+
+	value := ""
+
+	validator := func(val interface{}) error {
+		str, _ := val.(string)
+
+		if _, err := template.New("alias").Parse(str); err != nil {
+			return errors.New("Template string provided can't be rendered")
+		}
+
+		return nil
+	}
+
+	if err := survey.AskOne(prompt, &value, survey.WithValidator(validator)); err != nil {
+		return err
+	}
+
+	viper.Set("HostAlias", value)
+
+	return nil
+}
+
+func resetDefaults() error {
 	prompt := &survey.Confirm{
 		Message: "Are you sure you'd like to reset to defaults?",
 	}
@@ -225,10 +458,12 @@ func resetDefaults() {
 	value := false
 
 	if err := survey.AskOne(prompt, &value); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	if value {
 		SetDefaults(true)
 	}
+
+	return nil
 }
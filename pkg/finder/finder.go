@@ -0,0 +1,349 @@
+package finder
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+	"github.com/sahilm/fuzzy"
+)
+
+var ErrAborted = errors.New("Finder aborted")
+
+type Item struct {
+	Label   string
+	Search  string
+	Preview []string
+	Dimmed  bool
+}
+
+// Action is bound to a key. Run is given the highlighted item, returning true closes the finder,
+// otherwise the message is shown in the status line.
+type Action struct {
+	Key  tcell.Key
+	Name string
+	Run  func(idx int) (bool, string)
+}
+
+type searchSource []Item
+
+func (s searchSource) String(i int) string {
+	return s[i].Search
+}
+
+func (s searchSource) Len() int {
+	return len(s)
+}
+
+type finder struct {
+	screen  tcell.Screen
+	prompt  string
+	items   []Item
+	actions []Action
+	multi   bool
+	marked  map[int]bool
+	query   []rune
+	matches fuzzy.Matches
+	cursor  int
+	offset  int
+	status  string
+}
+
+var (
+	styleDefault = tcell.StyleDefault
+	styleCursor  = tcell.StyleDefault.Reverse(true)
+	styleDimmed  = tcell.StyleDefault.Foreground(tcell.ColorRed)
+	styleMatch   = tcell.StyleDefault.Foreground(tcell.ColorGreen).Bold(true)
+	styleBorder  = tcell.StyleDefault.Foreground(tcell.ColorGray)
+	styleHelp    = tcell.StyleDefault.Foreground(tcell.ColorGray)
+	stylePrompt  = tcell.StyleDefault.Foreground(tcell.ColorBlue).Bold(true)
+	styleStatus  = tcell.StyleDefault.Foreground(tcell.ColorYellow)
+)
+
+// Find opens a full-screen fuzzy finder, returning the chosen item index and the name of the action taken.
+func Find(prompt string, items []Item, actions []Action) (int, string, error) {
+	f := &finder{
+		prompt:  prompt,
+		items:   items,
+		actions: actions,
+	}
+
+	return f.run()
+}
+
+// FindMany lets items be marked with Tab, returning the marked indexes in order or the highlighted item if none are marked.
+func FindMany(prompt string, items []Item) ([]int, error) {
+	f := &finder{
+		prompt: prompt,
+		items:  items,
+		multi:  true,
+		marked: map[int]bool{},
+	}
+
+	f.actions = []Action{
+		{
+			Key:  tcell.KeyEnter,
+			Name: "select",
+			Run: func(idx int) (bool, string) {
+				return true, ""
+			},
+		},
+	}
+
+	idx, _, err := f.run()
+
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := []int{}
+
+	for i := range items {
+		if f.marked[i] {
+			indexes = append(indexes, i)
+		}
+	}
+
+	if len(indexes) == 0 {
+		indexes = append(indexes, idx)
+	}
+
+	return indexes, nil
+}
+
+func (f *finder) run() (int, string, error) {
+	screen, err := tcell.NewScreen()
+
+	if err != nil {
+		return -1, "", err
+	}
+
+	if err := screen.Init(); err != nil {
+		return -1, "", err
+	}
+
+	defer screen.Fini()
+
+	f.screen = screen
+
+	f.filter()
+
+	for {
+		f.draw()
+
+		switch ev := screen.PollEvent().(type) {
+		case *tcell.EventResize:
+			screen.Sync()
+		case *tcell.EventKey:
+			if idx, action, done, err := f.handleKey(ev); done {
+				return idx, action, err
+			}
+		}
+	}
+}
+
+func (f *finder) filter() {
+	query := string(f.query)
+
+	if query == "" {
+		f.matches = fuzzy.Matches{}
+
+		for idx := range f.items {
+			f.matches = append(f.matches, fuzzy.Match{Str: f.items[idx].Search, Index: idx})
+		}
+	} else {
+		f.matches = fuzzy.FindFrom(query, searchSource(f.items))
+	}
+
+	f.cursor, f.offset = 0, 0
+}
+
+func (f *finder) move(delta int) {
+	f.cursor += delta
+
+	if f.cursor >= len(f.matches) {
+		f.cursor = len(f.matches) - 1
+	}
+
+	if f.cursor < 0 {
+		f.cursor = 0
+	}
+}
+
+func (f *finder) handleKey(ev *tcell.EventKey) (int, string, bool, error) {
+	_, height := f.screen.Size()
+	page := height - 3
+
+	f.status = ""
+
+	switch ev.Key() {
+	case tcell.KeyEscape, tcell.KeyCtrlC:
+		return -1, "", true, ErrAborted
+	case tcell.KeyUp, tcell.KeyCtrlP:
+		f.move(-1)
+	case tcell.KeyDown, tcell.KeyCtrlN:
+		f.move(1)
+	case tcell.KeyPgUp:
+		f.move(-page)
+	case tcell.KeyPgDn:
+		f.move(page)
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if len(f.query) > 0 {
+			f.query = f.query[:len(f.query)-1]
+			f.filter()
+		}
+	case tcell.KeyCtrlU:
+		f.query = []rune{}
+		f.filter()
+	case tcell.KeyTab:
+		if f.multi && len(f.matches) > 0 {
+			idx := f.matches[f.cursor].Index
+			f.marked[idx] = !f.marked[idx]
+			f.move(1)
+		}
+	case tcell.KeyRune:
+		f.query = append(f.query, ev.Rune())
+		f.filter()
+	default:
+		for _, action := range f.actions {
+			if action.Key != ev.Key() || len(f.matches) == 0 {
+				continue
+			}
+
+			idx := f.matches[f.cursor].Index
+
+			done, message := action.Run(idx)
+
+			if done {
+				return idx, action.Name, true, nil
+			}
+
+			f.status = message
+		}
+	}
+
+	return -1, "", false, nil
+}
+
+func (f *finder) print(x int, y int, maxX int, text string, style tcell.Style) int {
+	for _, r := range text {
+		width := runewidth.RuneWidth(r)
+
+		if x+width > maxX {
+			break
+		}
+
+		f.screen.SetContent(x, y, r, nil, style)
+		x += width
+	}
+
+	return x
+}
+
+func (f *finder) drawItem(y int, maxX int, match fuzzy.Match, selected bool) {
+	item := f.items[match.Index]
+
+	matched := map[int]bool{}
+
+	for _, idx := range match.MatchedIndexes {
+		matched[idx] = true
+	}
+
+	base := styleDefault
+
+	if item.Dimmed {
+		base = styleDimmed
+	}
+
+	if selected {
+		base = base.Reverse(true)
+
+		for x := 0; x < maxX; x++ {
+			f.screen.SetContent(x, y, ' ', nil, styleCursor)
+		}
+	}
+
+	gutter := []rune("  ")
+
+	if selected {
+		gutter[0] = '>'
+	}
+
+	if f.marked[match.Index] {
+		gutter[1] = '*'
+	}
+
+	x := f.print(0, y, maxX, string(gutter), base)
+
+	// The label prefixes the search text, so matched indexes line up with it
+	for idx, r := range item.Label {
+		style := base
+
+		if matched[idx] {
+			style = styleMatch.Reverse(selected)
+		}
+
+		x = f.print(x, y, maxX, string(r), style)
+	}
+}
+
+func (f *finder) draw() {
+	f.screen.Clear()
+
+	width, height := f.screen.Size()
+	listWidth := width / 2
+	listHeight := height - 3
+
+	if f.cursor < f.offset {
+		f.offset = f.cursor
+	}
+
+	if f.cursor >= f.offset+listHeight {
+		f.offset = f.cursor - listHeight + 1
+	}
+
+	x := f.print(0, 0, width, f.prompt+" > ", stylePrompt)
+	x = f.print(x, 0, width, string(f.query), styleDefault)
+	f.screen.ShowCursor(x, 0)
+
+	for row := 0; row < listHeight && f.offset+row < len(f.matches); row++ {
+		f.drawItem(row+2, listWidth-1, f.matches[f.offset+row], f.offset+row == f.cursor)
+	}
+
+	for y := 1; y < height-1; y++ {
+		f.screen.SetContent(listWidth, y, tcell.RuneVLine, nil, styleBorder)
+	}
+
+	f.print(0, 1, listWidth, strings.Repeat("─", listWidth), styleBorder)
+	f.print(listWidth+2, 1, width, "Preview", styleHelp)
+
+	if len(f.matches) > 0 {
+		preview := f.items[f.matches[f.cursor].Index].Preview
+
+		for idx, line := range preview {
+			if idx+2 >= height-1 {
+				break
+			}
+
+			f.print(listWidth+2, idx+2, width, line, styleDefault)
+		}
+	}
+
+	help := []string{}
+
+	for _, action := range f.actions {
+		help = append(help, tcell.KeyNames[action.Key]+": "+action.Name)
+	}
+
+	if f.multi {
+		help = append(help, "Tab: mark")
+	}
+
+	help = append(help, "Esc: quit")
+
+	x = f.print(0, height-1, width, strings.Join(help, "  "), styleHelp)
+	f.print(x+2, height-1, width, f.status, styleStatus)
+
+	f.screen.Show()
+}
@@ -0,0 +1,27 @@
+package instances
+
+import (
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// ClientProvider creates the AWS clients used for a scope, allowing fakes to be injected.
+type ClientProvider interface {
+	EC2(scope *Scope) ec2iface.EC2API
+	SSM(scope *Scope) ssmiface.SSMAPI
+}
+
+// SessionProvider creates clients from the scope's session.
+type SessionProvider struct{}
+
+func (SessionProvider) EC2(scope *Scope) ec2iface.EC2API {
+	return ec2.New(scope.Session)
+}
+
+func (SessionProvider) SSM(scope *Scope) ssmiface.SSMAPI {
+	return ssm.New(scope.Session)
+}
+
+var DefaultClients ClientProvider = SessionProvider{}
@@ -0,0 +1,105 @@
+package instances
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+const ShellScriptDocument = "AWS-RunShellScript"
+
+// SendCommandLimit is the most instances a single SendCommand call may target.
+const SendCommandLimit = 50
+
+// minimumDeliveryTimeout is the shortest time SendCommand accepts for the agent to pick up a command.
+const minimumDeliveryTimeout = 30 * time.Second
+
+// GetDeliveryTimeout is how long the agent may take to pick up a command that may run for timeout.
+func GetDeliveryTimeout(timeout time.Duration) time.Duration {
+	if timeout < minimumDeliveryTimeout {
+		return minimumDeliveryTimeout
+	}
+
+	return timeout
+}
+
+// SendShellCommand runs the commands as a shell script on every instance, returning the command ID.
+// The timeout bounds both how long the agent may take to pick the command up and how long it may run.
+// AWS-RunShellScript runs commands as root.
+func SendShellCommand(svc ssmiface.SSMAPI, ids []string, commands []string, timeout time.Duration) (string, error) {
+	output, err := svc.SendCommand(&ssm.SendCommandInput{
+		DocumentName:   aws.String(ShellScriptDocument),
+		InstanceIds:    aws.StringSlice(ids),
+		TimeoutSeconds: aws.Int64(int64(GetDeliveryTimeout(timeout).Seconds())),
+		Parameters: map[string][]*string{
+			"commands":         aws.StringSlice(commands),
+			"executionTimeout": {aws.String(strconv.Itoa(int(timeout.Seconds())))},
+		},
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(output.Command.CommandId), nil
+}
+
+// CancelCommand stops the command on the instances, or on every instance it was sent to when none are given.
+func CancelCommand(svc ssmiface.SSMAPI, commandId string, ids []string) error {
+	input := &ssm.CancelCommandInput{CommandId: aws.String(commandId)}
+
+	if len(ids) > 0 {
+		input.InstanceIds = aws.StringSlice(ids)
+	}
+
+	_, err := svc.CancelCommand(input)
+
+	return err
+}
+
+type CommandInvocation struct {
+	Status       string
+	ResponseCode int64
+	Stdout       string
+	Stderr       string
+}
+
+// Done reports whether the invocation reached a final status.
+func (c *CommandInvocation) Done() bool {
+	switch c.Status {
+	case ssm.CommandInvocationStatusSuccess,
+		ssm.CommandInvocationStatusFailed,
+		ssm.CommandInvocationStatusCancelled,
+		ssm.CommandInvocationStatusTimedOut:
+		return true
+	}
+
+	return false
+}
+
+// GetCommandInvocation returns nil without an error until the invocation is registered, which lags behind SendCommand.
+func GetCommandInvocation(svc ssmiface.SSMAPI, commandId string, instanceId string) (*CommandInvocation, error) {
+	output, err := svc.GetCommandInvocation(&ssm.GetCommandInvocationInput{
+		CommandId:  aws.String(commandId),
+		InstanceId: aws.String(instanceId),
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeInvocationDoesNotExist {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &CommandInvocation{
+		Status:       aws.StringValue(output.Status),
+		ResponseCode: aws.Int64Value(output.ResponseCode),
+		Stdout:       aws.StringValue(output.StandardOutputContent),
+		Stderr:       aws.StringValue(output.StandardErrorContent),
+	}, nil
+}
@@ -0,0 +1,31 @@
+package instances
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2instanceconnect"
+)
+
+// SendSSHPublicKey authorizes the public key for the OS user during the next 60 seconds.
+func SendSSHPublicKey(sess *session.Session, instance *Instance, user string, publicKey string) error {
+	svc := ec2instanceconnect.New(sess)
+
+	output, err := svc.SendSSHPublicKey(&ec2instanceconnect.SendSSHPublicKeyInput{
+		AvailabilityZone: aws.String(instance.AvailabilityZone),
+		InstanceId:       aws.String(instance.InstanceId),
+		InstanceOSUser:   aws.String(user),
+		SSHPublicKey:     aws.String(publicKey),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if !aws.BoolValue(output.Success) {
+		return errors.New("EC2 Instance Connect rejected the public key")
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package instances
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+type NetworkInterface struct {
+	NetworkInterfaceId string
+	DeviceIndex        int64
+	SubnetId           string
+	VpcId              string
+	PrivateIpAddresses []string
+	PublicIpAddresses  []string
+	ElasticIpAddresses []string
+	Ipv6Addresses      []string
+}
+
+// amazonOwner owns auto-assigned public IPs, Elastic IPs are owned by the account
+const amazonOwner = "amazon"
+
+func convertNetworkInterface(eni *ec2.InstanceNetworkInterface) NetworkInterface {
+	converted := NetworkInterface{
+		NetworkInterfaceId: aws.StringValue(eni.NetworkInterfaceId),
+		SubnetId:           aws.StringValue(eni.SubnetId),
+		VpcId:              aws.StringValue(eni.VpcId),
+		PrivateIpAddresses: []string{},
+		PublicIpAddresses:  []string{},
+		ElasticIpAddresses: []string{},
+		Ipv6Addresses:      []string{},
+	}
+
+	if eni.Attachment != nil {
+		converted.DeviceIndex = aws.Int64Value(eni.Attachment.DeviceIndex)
+	}
+
+	// The primary address is listed first so callers can rely on ordering
+	for _, primary := range []bool{true, false} {
+		for _, ip := range eni.PrivateIpAddresses {
+			if aws.BoolValue(ip.Primary) != primary || ip.PrivateIpAddress == nil {
+				continue
+			}
+
+			converted.PrivateIpAddresses = append(converted.PrivateIpAddresses, *ip.PrivateIpAddress)
+
+			if ip.Association != nil && ip.Association.PublicIp != nil {
+				converted.PublicIpAddresses = append(converted.PublicIpAddresses, *ip.Association.PublicIp)
+
+				if aws.StringValue(ip.Association.IpOwnerId) != amazonOwner {
+					converted.ElasticIpAddresses = append(converted.ElasticIpAddresses, *ip.Association.PublicIp)
+				}
+			}
+		}
+	}
+
+	if len(converted.PrivateIpAddresses) == 0 && eni.PrivateIpAddress != nil {
+		converted.PrivateIpAddresses = append(converted.PrivateIpAddresses, *eni.PrivateIpAddress)
+	}
+
+	for _, ip := range eni.Ipv6Addresses {
+		if ip.Ipv6Address != nil {
+			converted.Ipv6Addresses = append(converted.Ipv6Addresses, *ip.Ipv6Address)
+		}
+	}
+
+	return converted
+}
+
+// FromEC2 converts an EC2 instance, tolerating any optional field being absent.
+// Private-only, key-less and terminated instances simply leave those fields empty.
+func FromEC2(i *ec2.Instance) Instance {
+	instance := Instance{
+		ImageId:           aws.StringValue(i.ImageId),
+		InstanceId:        aws.StringValue(i.InstanceId),
+		InstanceType:      aws.StringValue(i.InstanceType),
+		KeyName:           aws.StringValue(i.KeyName),
+		NetworkInterfaces: []NetworkInterface{},
+		PrivateDnsName:    aws.StringValue(i.PrivateDnsName),
+		PrivateIpAddress:  aws.StringValue(i.PrivateIpAddress),
+		PublicDnsName:     aws.StringValue(i.PublicDnsName),
+		PublicIpAddress:   aws.StringValue(i.PublicIpAddress),
+		SubnetId:          aws.StringValue(i.SubnetId),
+		VpcId:             aws.StringValue(i.VpcId),
+		Tags:              RemapTags(i.Tags),
+	}
+
+	if i.Placement != nil {
+		instance.AvailabilityZone = aws.StringValue(i.Placement.AvailabilityZone)
+	}
+
+	if i.State != nil {
+		instance.State = aws.StringValue(i.State.Name)
+	}
+
+	for _, eni := range i.NetworkInterfaces {
+		if eni != nil {
+			instance.NetworkInterfaces = append(instance.NetworkInterfaces, convertNetworkInterface(eni))
+		}
+	}
+
+	for _, eni := range instance.NetworkInterfaces {
+		if eni.DeviceIndex != 0 {
+			continue
+		}
+
+		if instance.VpcId == "" {
+			instance.VpcId = eni.VpcId
+		}
+
+		if instance.SubnetId == "" {
+			instance.SubnetId = eni.SubnetId
+		}
+	}
+
+	return instance
+}
@@ -0,0 +1,179 @@
+package instances
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func loadDescribeInstances(t *testing.T, name string) []*ec2.Instance {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output ec2.DescribeInstancesOutput
+
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+
+	instances := []*ec2.Instance{}
+
+	for _, res := range output.Reservations {
+		instances = append(instances, res.Instances...)
+	}
+
+	return instances
+}
+
+func TestFromEC2(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		expected Instance
+	}{
+		{
+			fixture: "public.json",
+			expected: Instance{
+				AvailabilityZone: "us-east-1a",
+				ImageId:          "ami-0c02fb55956c7d316",
+				InstanceId:       "i-0123456789abcdef0",
+				InstanceType:     "t3.micro",
+				KeyName:          "deploy",
+				NetworkInterfaces: []NetworkInterface{
+					{
+						NetworkInterfaceId: "eni-0a1b2c3d",
+						SubnetId:           "subnet-0a1b2c3d",
+						VpcId:              "vpc-0a1b2c3d",
+						PrivateIpAddresses: []string{"172.31.10.20"},
+						PublicIpAddresses:  []string{"54.1.2.3"},
+						ElasticIpAddresses: []string{},
+						Ipv6Addresses:      []string{},
+					},
+				},
+				PrivateDnsName:   "ip-172-31-10-20.ec2.internal",
+				PrivateIpAddress: "172.31.10.20",
+				PublicDnsName:    "ec2-54-1-2-3.compute-1.amazonaws.com",
+				PublicIpAddress:  "54.1.2.3",
+				SubnetId:         "subnet-0a1b2c3d",
+				VpcId:            "vpc-0a1b2c3d",
+				State:            "running",
+				Tags:             map[string]string{"Name": "web-1", "Env": "prod"},
+			},
+		},
+		{
+			fixture: "private_without_key.json",
+			expected: Instance{
+				AvailabilityZone: "us-east-1b",
+				ImageId:          "ami-0c02fb55956c7d316",
+				InstanceId:       "i-0223456789abcdef0",
+				InstanceType:     "m5.large",
+				NetworkInterfaces: []NetworkInterface{
+					{
+						NetworkInterfaceId: "eni-0b1b2c3d",
+						SubnetId:           "subnet-0b1b2c3d",
+						VpcId:              "vpc-0b1b2c3d",
+						PrivateIpAddresses: []string{"10.0.1.5"},
+						PublicIpAddresses:  []string{},
+						ElasticIpAddresses: []string{},
+						Ipv6Addresses:      []string{},
+					},
+				},
+				PrivateDnsName:   "ip-10-0-1-5.ec2.internal",
+				PrivateIpAddress: "10.0.1.5",
+				SubnetId:         "subnet-0b1b2c3d",
+				VpcId:            "vpc-0b1b2c3d",
+				State:            "running",
+				Tags:             map[string]string{"Name": "No Name"},
+			},
+		},
+		{
+			fixture: "terminated.json",
+			expected: Instance{
+				AvailabilityZone:  "us-east-1c",
+				ImageId:           "ami-0c02fb55956c7d316",
+				InstanceId:        "i-0323456789abcdef0",
+				InstanceType:      "t3.small",
+				KeyName:           "deploy",
+				NetworkInterfaces: []NetworkInterface{},
+				State:             "terminated",
+				Tags:              map[string]string{"Name": "old-worker"},
+			},
+		},
+		{
+			fixture: "multiple_interfaces.json",
+			expected: Instance{
+				AvailabilityZone: "us-east-1a",
+				ImageId:          "ami-0d02fb55956c7d316",
+				InstanceId:       "i-0423456789abcdef0",
+				InstanceType:     "c5.xlarge",
+				KeyName:          "bastion",
+				NetworkInterfaces: []NetworkInterface{
+					{
+						NetworkInterfaceId: "eni-0e1b2c3d",
+						DeviceIndex:        1,
+						SubnetId:           "subnet-0e1b2c3d",
+						VpcId:              "vpc-0d1b2c3d",
+						PrivateIpAddresses: []string{"10.1.1.10"},
+						PublicIpAddresses:  []string{"3.4.5.6"},
+						ElasticIpAddresses: []string{"3.4.5.6"},
+						Ipv6Addresses:      []string{"2600:1f18:aaaa:bbbb::10"},
+					},
+					{
+						NetworkInterfaceId: "eni-0d1b2c3d",
+						SubnetId:           "subnet-0d1b2c3d",
+						VpcId:              "vpc-0d1b2c3d",
+						PrivateIpAddresses: []string{"10.1.0.10", "10.1.0.11"},
+						PublicIpAddresses:  []string{},
+						ElasticIpAddresses: []string{},
+						Ipv6Addresses:      []string{},
+					},
+				},
+				PrivateDnsName:   "ip-10-1-0-10.ec2.internal",
+				PrivateIpAddress: "10.1.0.10",
+				SubnetId:         "subnet-0d1b2c3d",
+				VpcId:            "vpc-0d1b2c3d",
+				State:            "stopped",
+				Tags:             map[string]string{"Name": "bastion"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			instances := loadDescribeInstances(t, tt.fixture)
+
+			if len(instances) != 1 {
+				t.Fatalf("expected 1 instance in fixture, got %d", len(instances))
+			}
+
+			actual := FromEC2(instances[0])
+
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("FromEC2() mismatch\nexpected: %+v\nactual:   %+v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFromEC2Empty(t *testing.T) {
+	actual := FromEC2(&ec2.Instance{
+		NetworkInterfaces: []*ec2.InstanceNetworkInterface{{}},
+		Tags:              []*ec2.Tag{{}},
+	})
+
+	if actual.Tags["Name"] != "No Name" {
+		t.Errorf("expected default Name tag, got %q", actual.Tags["Name"])
+	}
+
+	if len(actual.NetworkInterfaces) != 1 || len(actual.NetworkInterfaces[0].PrivateIpAddresses) != 0 {
+		t.Errorf("expected one empty network interface, got %+v", actual.NetworkInterfaces)
+	}
+}
@@ -0,0 +1,234 @@
+// Package fake provides in-memory AWS clients for exercising instance discovery without network access.
+package fake
+
+import (
+	"fmt"
+	"sync"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// EC2 serves Instances through DescribeInstancesPages, PageSize instances per page in reservations of OwnerId, Images through DescribeImages
+// and KeyPairs through DescribeKeyPairs. DescribeInstancesPages fails with Err when set.
+// Any method not overridden panics through the embedded nil interface.
+type EC2 struct {
+	ec2iface.EC2API
+
+	Instances []*ec2.Instance
+	OwnerId   string
+	PageSize  int
+	Images    []*ec2.Image
+	KeyPairs  []*ec2.KeyPairInfo
+	Err       error
+
+	mu            sync.Mutex
+	Inputs        []*ec2.DescribeInstancesInput
+	ImageInputs   []*ec2.DescribeImagesInput
+	KeyPairInputs []*ec2.DescribeKeyPairsInput
+}
+
+// DescribeKeyPairs fails like EC2 when any requested name is unknown.
+func (f *EC2) DescribeKeyPairs(input *ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error) {
+	f.mu.Lock()
+	f.KeyPairInputs = append(f.KeyPairInputs, input)
+	f.mu.Unlock()
+
+	output := &ec2.DescribeKeyPairsOutput{KeyPairs: []*ec2.KeyPairInfo{}}
+
+	for _, name := range input.KeyNames {
+		found := false
+
+		for _, pair := range f.KeyPairs {
+			if aws.StringValue(pair.KeyName) == aws.StringValue(name) {
+				output.KeyPairs = append(output.KeyPairs, pair)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, awserr.New(inst.ErrCodeKeyPairNotFound, fmt.Sprintf("The key pair '%s' does not exist", aws.StringValue(name)), nil)
+		}
+	}
+
+	return output, nil
+}
+
+func (f *EC2) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	f.mu.Lock()
+	f.ImageInputs = append(f.ImageInputs, input)
+	f.mu.Unlock()
+
+	output := &ec2.DescribeImagesOutput{Images: []*ec2.Image{}}
+
+	for _, image := range f.Images {
+		for _, id := range input.ImageIds {
+			if aws.StringValue(image.ImageId) == aws.StringValue(id) {
+				output.Images = append(output.Images, image)
+			}
+		}
+	}
+
+	return output, nil
+}
+
+func (f *EC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	f.mu.Lock()
+	f.Inputs = append(f.Inputs, input)
+	f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	size := f.PageSize
+
+	if size <= 0 {
+		size = len(f.Instances)
+	}
+
+	pages := [][]*ec2.Instance{}
+
+	for start := 0; start < len(f.Instances); start += size {
+		end := start + size
+
+		if end > len(f.Instances) {
+			end = len(f.Instances)
+		}
+
+		pages = append(pages, f.Instances[start:end])
+	}
+
+	if len(pages) == 0 {
+		pages = append(pages, []*ec2.Instance{})
+	}
+
+	for idx, page := range pages {
+		output := &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: page, OwnerId: aws.String(f.OwnerId)}},
+		}
+
+		if !fn(output, idx == len(pages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// SSM serves Information through DescribeInstanceInformationPages, PageSize entries per page, failing with Err when set.
+// Invocations are returned in order for each instance by GetCommandInvocation, repeating the last one,
+// instances without any report InvocationDoesNotExist. Cancelled records every CancelCommand call.
+type SSM struct {
+	ssmiface.SSMAPI
+
+	Information []*ssm.InstanceInformation
+	PageSize    int
+	Err         error
+	Invocations map[string][]*ssm.GetCommandInvocationOutput
+
+	mu        sync.Mutex
+	Commands  []*ssm.SendCommandInput
+	Cancelled []*ssm.CancelCommandInput
+	polls     map[string]int
+}
+
+func (f *SSM) DescribeInstanceInformationPages(input *ssm.DescribeInstanceInformationInput, fn func(*ssm.DescribeInstanceInformationOutput, bool) bool) error {
+	if f.Err != nil {
+		return f.Err
+	}
+
+	size := f.PageSize
+
+	if size <= 0 || size > len(f.Information) {
+		size = len(f.Information)
+	}
+
+	for start := 0; ; start += size {
+		end := start + size
+
+		if end > len(f.Information) {
+			end = len(f.Information)
+		}
+
+		lastPage := end == len(f.Information)
+
+		if !fn(&ssm.DescribeInstanceInformationOutput{InstanceInformationList: f.Information[start:end]}, lastPage) || lastPage {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (f *SSM) SendCommand(input *ssm.SendCommandInput) (*ssm.SendCommandOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Commands = append(f.Commands, input)
+
+	return &ssm.SendCommandOutput{
+		Command: &ssm.Command{CommandId: aws.String(fmt.Sprintf("command-%d", len(f.Commands)))},
+	}, nil
+}
+
+func (f *SSM) CancelCommand(input *ssm.CancelCommandInput) (*ssm.CancelCommandOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Cancelled = append(f.Cancelled, input)
+
+	return &ssm.CancelCommandOutput{}, nil
+}
+
+func (f *SSM) GetCommandInvocation(input *ssm.GetCommandInvocationInput) (*ssm.GetCommandInvocationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := aws.StringValue(input.InstanceId)
+	invocations := f.Invocations[id]
+
+	if len(invocations) == 0 {
+		return nil, awserr.New(ssm.ErrCodeInvocationDoesNotExist, "invocation does not exist", nil)
+	}
+
+	if f.polls == nil {
+		f.polls = map[string]int{}
+	}
+
+	idx := f.polls[id]
+
+	if idx < len(invocations)-1 {
+		f.polls[id]++
+	}
+
+	return invocations[idx], nil
+}
+
+// Provider hands out the fake clients registered for a scope's region.
+// Regions without registered clients list nothing.
+type Provider struct {
+	EC2Clients map[string]*EC2
+	SSMClients map[string]*SSM
+}
+
+func (p *Provider) EC2(scope *inst.Scope) ec2iface.EC2API {
+	if client, found := p.EC2Clients[scope.Region]; found {
+		return client
+	}
+
+	return &EC2{}
+}
+
+func (p *Provider) SSM(scope *inst.Scope) ssmiface.SSMAPI {
+	if client, found := p.SSMClients[scope.Region]; found {
+		return client
+	}
+
+	return &SSM{}
+}
@@ -0,0 +1,43 @@
+package instances
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Image holds the AMI details used to infer an instance's login user.
+type Image struct {
+	ImageId    string
+	Name       string
+	OwnerId    string
+	OwnerAlias string
+	Platform   string
+}
+
+// DescribeImage looks up a single AMI, returning nil when it's no longer visible to the account.
+func DescribeImage(svc ec2iface.EC2API, id string) (*Image, error) {
+	output, err := svc.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: aws.StringSlice([]string{id}),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, image := range output.Images {
+		if aws.StringValue(image.ImageId) != id {
+			continue
+		}
+
+		return &Image{
+			ImageId:    id,
+			Name:       aws.StringValue(image.Name),
+			OwnerId:    aws.StringValue(image.OwnerId),
+			OwnerAlias: aws.StringValue(image.ImageOwnerAlias),
+			Platform:   aws.StringValue(image.PlatformDetails),
+		}, nil
+	}
+
+	return nil, nil
+}
@@ -1,36 +1,60 @@
 package instances
 
 import (
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
 type Instance struct {
-	ImageId          string
-	InstanceId       string
-	InstanceType     string
-	KeyName          string
-	PrivateIpAddress string
-	PublicIpAddress  string
-	SubnetId         string
-	VpcId            string
-	SSMEnabled       bool
-	State            string
-	Tags             map[string]string
+	AccountId         string
+	AvailabilityZone  string
+	ImageId           string
+	InstanceId        string
+	InstanceType      string
+	KeyName           string
+	NetworkInterfaces []NetworkInterface
+	PrivateDnsName    string
+	PrivateIpAddress  string
+	Profile           string
+	PublicDnsName     string
+	PublicIpAddress   string
+	Region            string
+	SubnetId          string
+	VpcId             string
+	SSMAgentVersion   string
+	SSMEnabled        bool
+	SSMPingStatus     string
+	Stale             bool `yaml:"-"`
+	State             string
+	Tags              map[string]string
 }
 
-func GetInstancesChannel(sess *session.Session) <-chan *ec2.Instance {
-	c := make(chan *ec2.Instance)
+// ReservedInstance is a described instance along with the account owning its reservation.
+type ReservedInstance struct {
+	Instance  *ec2.Instance
+	AccountId string
+}
+
+// GetInstancesChannel streams the described instances, the error channel receives the outcome once they are all sent.
+func GetInstancesChannel(svc ec2iface.EC2API, filters []*ec2.Filter) (<-chan *ReservedInstance, <-chan error) {
+	c := make(chan *ReservedInstance)
+	errc := make(chan error, 1)
 
 	channelInstances := func() {
-		svc := ec2.New(sess)
+		input := &ec2.DescribeInstancesInput{}
 
-		svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{},
+		if len(filters) > 0 {
+			input.Filters = filters
+		}
+
+		errc <- svc.DescribeInstancesPages(input,
 			func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
 				for _, res := range page.Reservations {
 					for _, inst := range res.Instances {
-						c <- inst
+						c <- &ReservedInstance{Instance: inst, AccountId: aws.StringValue(res.OwnerId)}
 					}
 				}
 
@@ -42,19 +66,19 @@ func GetInstancesChannel(sess *session.Session) <-chan *ec2.Instance {
 
 	go channelInstances()
 
-	return c
+	return c, errc
 }
 
-func GetInstanceInfoChannel(sess *session.Session) <-chan *ssm.InstanceInformation {
+// GetInstanceInfoChannel streams the instances known to SSM, the error channel receives the outcome once they are all sent.
+func GetInstanceInfoChannel(svc ssmiface.SSMAPI) (<-chan *ssm.InstanceInformation, <-chan error) {
 	c := make(chan *ssm.InstanceInformation)
+	errc := make(chan error, 1)
 
 	channelInstanceInfo := func() {
-		svc := ssm.New(sess)
-
-		svc.DescribeInstanceInformationPages(&ssm.DescribeInstanceInformationInput{},
+		errc <- svc.DescribeInstanceInformationPages(&ssm.DescribeInstanceInformationInput{},
 			func(page *ssm.DescribeInstanceInformationOutput, lastPage bool) bool {
 				for _, info := range page.InstanceInformationList {
-					if *info.AssociationStatus == "Status" {
+					if info != nil && info.InstanceId != nil {
 						c <- info
 					}
 				}
@@ -67,14 +91,16 @@ func GetInstanceInfoChannel(sess *session.Session) <-chan *ssm.InstanceInformati
 
 	go channelInstanceInfo()
 
-	return c
+	return c, errc
 }
 
 func RemapTags(tags []*ec2.Tag) map[string]string {
 	remapped := map[string]string{}
 
 	for _, tag := range tags {
-		remapped[*tag.Key] = *tag.Value
+		if tag != nil && tag.Key != nil {
+			remapped[*tag.Key] = aws.StringValue(tag.Value)
+		}
 	}
 
 	if _, found := remapped["Name"]; !found {
@@ -0,0 +1,45 @@
+package instances
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// ErrCodeKeyPairNotFound is returned by DescribeKeyPairs for unknown key names
+const ErrCodeKeyPairNotFound = "InvalidKeyPair.NotFound"
+
+// KeyPair holds the fingerprint EC2 recorded for a key pair.
+type KeyPair struct {
+	Name        string
+	Fingerprint string
+	Type        string
+}
+
+// DescribeKeyPair looks up a key pair by name, returning nil when it no longer exists.
+func DescribeKeyPair(svc ec2iface.EC2API, name string) (*KeyPair, error) {
+	output, err := svc.DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
+		KeyNames: aws.StringSlice([]string{name}),
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ErrCodeKeyPairNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pair := range output.KeyPairs {
+		if aws.StringValue(pair.KeyName) == name {
+			return &KeyPair{
+				Name:        name,
+				Fingerprint: aws.StringValue(pair.KeyFingerprint),
+				Type:        aws.StringValue(pair.KeyType),
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
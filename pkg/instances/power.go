@@ -0,0 +1,76 @@
+package instances
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+type PowerAction string
+
+const (
+	PowerStart     PowerAction = "start"
+	PowerStop      PowerAction = "stop"
+	PowerReboot    PowerAction = "reboot"
+	PowerHibernate PowerAction = "hibernate"
+)
+
+var PowerActions = []string{
+	string(PowerStart),
+	string(PowerStop),
+	string(PowerReboot),
+	string(PowerHibernate),
+}
+
+// TargetState is the instance state an action settles in once complete. A reboot stays running throughout,
+// so it has no state to wait for.
+func (action PowerAction) TargetState() string {
+	switch action {
+	case PowerStop, PowerHibernate:
+		return ec2.InstanceStateNameStopped
+	case PowerReboot:
+		return ""
+	default:
+		return ec2.InstanceStateNameRunning
+	}
+}
+
+func ChangePower(svc ec2iface.EC2API, id string, action PowerAction) error {
+	ids := []*string{aws.String(id)}
+
+	var err error
+
+	switch action {
+	case PowerStart:
+		_, err = svc.StartInstances(&ec2.StartInstancesInput{InstanceIds: ids})
+	case PowerStop:
+		_, err = svc.StopInstances(&ec2.StopInstancesInput{InstanceIds: ids})
+	case PowerHibernate:
+		_, err = svc.StopInstances(&ec2.StopInstancesInput{InstanceIds: ids, Hibernate: aws.Bool(true)})
+	case PowerReboot:
+		_, err = svc.RebootInstances(&ec2.RebootInstancesInput{InstanceIds: ids})
+	default:
+		err = fmt.Errorf("Unknown power action [%s]", action)
+	}
+
+	return err
+}
+
+func WaitForState(svc ec2iface.EC2API, id string, state string) error {
+	input := &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(id)},
+	}
+
+	switch state {
+	case ec2.InstanceStateNameRunning:
+		return svc.WaitUntilInstanceRunning(input)
+	case ec2.InstanceStateNameStopped:
+		return svc.WaitUntilInstanceStopped(input)
+	case ec2.InstanceStateNameTerminated:
+		return svc.WaitUntilInstanceTerminated(input)
+	}
+
+	return fmt.Errorf("Unable to wait for state [%s]", state)
+}
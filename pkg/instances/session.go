@@ -1,11 +1,22 @@
 package instances
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
-func GetSession(profile string, region string) *session.Session {
+// Scope is a single profile and region pair instances are discovered in.
+type Scope struct {
+	Profile string
+	Region  string
+	Session *session.Session
+}
+
+func GetSession(profile string, region string) (*session.Session, error) {
 	options := session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}
@@ -20,5 +31,115 @@ func GetSession(profile string, region string) *session.Session {
 		}
 	}
 
-	return session.Must(session.NewSessionWithOptions(options))
+	return session.NewSessionWithOptions(options)
+}
+
+func NewScope(profile string, region string) (*Scope, error) {
+	sess, err := GetSession(profile, region)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scope{
+		Profile: profile,
+		Region:  aws.StringValue(sess.Config.Region),
+		Session: sess,
+	}, nil
+}
+
+// String names the scope in messages, the shared config's default profile being "default".
+func (scope *Scope) String() string {
+	profile := scope.Profile
+
+	if profile == "" {
+		profile = "default"
+	}
+
+	return fmt.Sprintf("%s/%s", profile, scope.Region)
+}
+
+func GetEnabledRegions(profile string) ([]string, error) {
+	sess, err := GetSession(profile, "")
+
+	if err == nil && aws.StringValue(sess.Config.Region) == "" {
+		sess, err = GetSession(profile, "us-east-1")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	svc := ec2.New(sess)
+
+	output, err := svc.DescribeRegions(&ec2.DescribeRegionsInput{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	regions := []string{}
+
+	for _, region := range output.Regions {
+		regions = append(regions, *region.RegionName)
+	}
+
+	return regions, nil
+}
+
+// GetScopes creates a scope for every profile and region combination.
+// Empty profiles or regions fall back to the shared config defaults.
+func GetScopes(profiles []string, regions []string, allRegions bool) ([]*Scope, error) {
+	if len(profiles) == 0 {
+		profiles = []string{""}
+	}
+
+	if len(regions) == 0 {
+		regions = []string{""}
+	}
+
+	scopes := []*Scope{}
+
+	for _, profile := range profiles {
+		profileRegions := regions
+
+		if allRegions {
+			enabled, err := GetEnabledRegions(profile)
+
+			if err != nil {
+				return nil, err
+			}
+
+			profileRegions = enabled
+		}
+
+		for _, region := range profileRegions {
+			scope, err := NewScope(profile, region)
+
+			if err != nil {
+				return nil, err
+			}
+
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+// ForEachScope runs fn concurrently for every scope and waits for all of them to finish.
+func ForEachScope(scopes []*Scope, fn func(idx int, scope *Scope)) {
+	var wg sync.WaitGroup
+
+	for idx, scope := range scopes {
+		wg.Add(1)
+
+		go func(idx int, scope *Scope) {
+			defer wg.Done()
+
+			fn(idx, scope)
+		}(idx, scope)
+	}
+
+	wg.Wait()
 }
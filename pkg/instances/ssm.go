@@ -0,0 +1,125 @@
+package instances
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+const (
+	SSHSessionDocument           = "AWS-StartSSHSession"
+	RemoteHostForwardingDocument = "AWS-StartPortForwardingSessionToRemoteHost"
+)
+
+type SSMSession struct {
+	Input    *ssm.StartSessionInput
+	Output   *ssm.StartSessionOutput
+	Endpoint string
+	Region   string
+}
+
+func StartSSMSession(sess *session.Session, target string, document string, parameters map[string]string) (*SSMSession, error) {
+	svc := ssm.New(sess)
+
+	input := &ssm.StartSessionInput{
+		Target:       aws.String(target),
+		DocumentName: aws.String(document),
+		Parameters:   map[string][]*string{},
+	}
+
+	for key, value := range parameters {
+		input.Parameters[key] = []*string{aws.String(value)}
+	}
+
+	output, err := svc.StartSession(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSMSession{
+		Input:    input,
+		Output:   output,
+		Endpoint: svc.Endpoint,
+		Region:   aws.StringValue(sess.Config.Region),
+	}, nil
+}
+
+func TerminateSSMSession(sess *session.Session, id string) error {
+	svc := ssm.New(sess)
+
+	_, err := svc.TerminateSession(&ssm.TerminateSessionInput{
+		SessionId: aws.String(id),
+	})
+
+	return err
+}
+
+// MinimumSSHAgentVersion is the first SSM agent release supporting AWS-StartSSHSession.
+const MinimumSSHAgentVersion = "2.3.672.0"
+
+// MinimumRemoteHostAgentVersion is the first SSM agent release supporting AWS-StartPortForwardingSessionToRemoteHost.
+const MinimumRemoteHostAgentVersion = "3.1.1374.0"
+
+type SSMStatus struct {
+	PingStatus   string
+	AgentVersion string
+}
+
+// Reachable reports whether an SSH session can be started through the agent.
+func (status SSMStatus) Reachable() bool {
+	if status.PingStatus != ssm.PingStatusOnline || status.AgentVersion == "" {
+		return false
+	}
+
+	return CompareVersions(status.AgentVersion, MinimumSSHAgentVersion) >= 0
+}
+
+// CompareVersions compares dotted numeric versions, returning -1, 0 or 1.
+func CompareVersions(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+
+	for idx := 0; idx < len(as) || idx < len(bs); idx++ {
+		var av, bv int
+
+		if idx < len(as) {
+			av, _ = strconv.Atoi(as[idx])
+		}
+
+		if idx < len(bs) {
+			bv, _ = strconv.Atoi(bs[idx])
+		}
+
+		if av != bv {
+			if av < bv {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func GetSSMStatuses(svc ssmiface.SSMAPI) (map[string]SSMStatus, error) {
+	statuses := map[string]SSMStatus{}
+	infoChan, errc := GetInstanceInfoChannel(svc)
+
+	for info := range infoChan {
+		statuses[*info.InstanceId] = SSMStatus{
+			PingStatus:   aws.StringValue(info.PingStatus),
+			AgentVersion: aws.StringValue(info.AgentVersion),
+		}
+	}
+
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
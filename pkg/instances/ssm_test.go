@@ -0,0 +1,95 @@
+package instances_test
+
+import (
+	"reflect"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/instances/fake"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestGetSSMStatuses(t *testing.T) {
+	svc := &fake.SSM{
+		PageSize: 2,
+		Information: []*ssm.InstanceInformation{
+			{
+				InstanceId:   aws.String("i-online"),
+				PingStatus:   aws.String(ssm.PingStatusOnline),
+				AgentVersion: aws.String("3.1.501.0"),
+			},
+			{
+				InstanceId: aws.String("i-no-association"),
+				PingStatus: aws.String(ssm.PingStatusConnectionLost),
+			},
+			{
+				InstanceId:        aws.String("i-old-agent"),
+				PingStatus:        aws.String(ssm.PingStatusOnline),
+				AgentVersion:      aws.String("2.3.50.0"),
+				AssociationStatus: aws.String("Success"),
+			},
+			{
+				PingStatus: aws.String(ssm.PingStatusOnline),
+			},
+		},
+	}
+
+	expected := map[string]inst.SSMStatus{
+		"i-online":         {PingStatus: "Online", AgentVersion: "3.1.501.0"},
+		"i-no-association": {PingStatus: "ConnectionLost"},
+		"i-old-agent":      {PingStatus: "Online", AgentVersion: "2.3.50.0"},
+	}
+
+	actual, err := inst.GetSSMStatuses(svc)
+
+	if err != nil {
+		t.Fatalf("GetSSMStatuses() error: %v", err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("GetSSMStatuses() mismatch\nexpected: %+v\nactual:   %+v", expected, actual)
+	}
+}
+
+func TestSSMStatusReachable(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    inst.SSMStatus
+		reachable bool
+	}{
+		{"online", inst.SSMStatus{PingStatus: "Online", AgentVersion: "3.1.501.0"}, true},
+		{"minimum agent", inst.SSMStatus{PingStatus: "Online", AgentVersion: inst.MinimumSSHAgentVersion}, true},
+		{"old agent", inst.SSMStatus{PingStatus: "Online", AgentVersion: "2.3.50.0"}, false},
+		{"missing agent version", inst.SSMStatus{PingStatus: "Online"}, false},
+		{"connection lost", inst.SSMStatus{PingStatus: "ConnectionLost", AgentVersion: "3.1.501.0"}, false},
+		{"unregistered", inst.SSMStatus{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.status.Reachable(); actual != tt.reachable {
+				t.Errorf("Reachable() = %t, expected %t", actual, tt.reachable)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2.3.672.0", "2.3.672.0", 0},
+		{"2.3.672", "2.3.672.0", 0},
+		{"2.3.1000.0", "2.3.672.0", 1},
+		{"2.2.999.0", "2.3.672.0", -1},
+		{"10.0.0.0", "9.9.9.9", 1},
+	}
+
+	for _, tt := range tests {
+		if actual := inst.CompareVersions(tt.a, tt.b); actual != tt.expected {
+			t.Errorf("CompareVersions(%q, %q) = %d, expected %d", tt.a, tt.b, actual, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const agentPrefix = "agent: "
+
+// AgentIdentity is a key loaded in the ssh-agent at SSH_AUTH_SOCK, such as ssh-agent or 1Password's agent.
+type AgentIdentity struct {
+	Comment      string
+	Type         string
+	Fingerprint  string
+	fingerprints []string
+}
+
+// Label is shown in the key picker, the private key never leaves the agent so there's no file to show.
+func (a *AgentIdentity) Label() string {
+	name := a.Comment
+
+	if name == "" {
+		name = a.Type
+	}
+
+	return fmt.Sprintf("%s%s (%s)", agentPrefix, name, a.Fingerprint)
+}
+
+// Matches reports whether the identity has the given EC2 fingerprint. EC2 reports the SHA-1 of the private key
+// for RSA key pairs it created, which agents don't expose, so only imported and ed25519 key pairs can match.
+func (a *AgentIdentity) Matches(fingerprint string) bool {
+	return containsFingerprint(a.fingerprints, fingerprint)
+}
+
+func newAgentIdentity(key *agent.Key) (*AgentIdentity, error) {
+	public, err := gossh.ParsePublicKey(key.Blob)
+
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &AgentIdentity{
+		Comment:     key.Comment,
+		Type:        key.Type(),
+		Fingerprint: gossh.FingerprintSHA256(public),
+	}
+
+	if cryptoKey, ok := public.(gossh.CryptoPublicKey); ok {
+		identity.fingerprints = getPublicKeyFingerprints(cryptoKey.CryptoPublicKey())
+	} else {
+		// Certificates and security keys only have the OpenSSH fingerprint
+		identity.fingerprints = []string{normalizeFingerprint(identity.Fingerprint)}
+	}
+
+	return identity, nil
+}
+
+// GetAgentIdentities lists the identities loaded in the agent, none without SSH_AUTH_SOCK.
+func GetAgentIdentities() ([]*AgentIdentity, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+
+	if sock == "" {
+		return nil, nil
+	}
+
+	conn, err := net.DialTimeout("unix", sock, 2*time.Second)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+
+	if err != nil {
+		return nil, err
+	}
+
+	identities := []*AgentIdentity{}
+
+	for _, key := range keys {
+		if identity, err := newAgentIdentity(key); err == nil {
+			identities = append(identities, identity)
+		}
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,138 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/instances/fake"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/viper"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serveAgent loads the fixtures into an in-memory agent listening at SSH_AUTH_SOCK.
+func serveAgent(t *testing.T, fixtures ...string) {
+	t.Helper()
+
+	keyring := agent.NewKeyring()
+
+	for _, fixture := range fixtures {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := gossh.ParseRawPrivateKey(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key, Comment: fixture}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+
+	listener, err := net.Listen("unix", sock)
+
+	if err != nil {
+		t.Skip("unix sockets unavailable:", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", sock)
+}
+
+func TestGetAgentIdentities(t *testing.T) {
+	setupConfig(t)
+	serveAgent(t, "ed25519")
+
+	identities, err := GetAgentIdentities()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(identities) != 1 {
+		t.Fatalf("expected 1 identity, got %d", len(identities))
+	}
+
+	expected := "agent: ed25519 (SHA256:gpZkuiGqtVP0lcQWq55uNu7qnatYVMVMUjxHHI+24bo)"
+
+	if label := identities[0].Label(); label != expected {
+		t.Errorf("Label() = %q, expected %q", label, expected)
+	}
+}
+
+func TestGetAgentIdentitiesWithoutAgent(t *testing.T) {
+	setupConfig(t)
+
+	identities, err := GetAgentIdentities()
+
+	if err != nil || len(identities) != 0 {
+		t.Errorf("GetAgentIdentities() = %v, %v, expected none", identities, err)
+	}
+}
+
+func TestPromptKeyMatchesAgentIdentity(t *testing.T) {
+	tests := []struct {
+		name        string
+		fingerprint string
+	}{
+		{"imported rsa", "74:bd:ed:a1:80:b8:2c:df:48:4b:a9:84:03:04:f4:03"},
+		{"ed25519", "gpZkuiGqtVP0lcQWq55uNu7qnatYVMVMUjxHHI+24bo="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfig(t)
+			serveAgent(t, "rsa.pem", "ed25519")
+			t.Cleanup(func() { keyPairs = map[string]*inst.KeyPair{} })
+
+			// Keys living only in the agent leave the keys directory empty
+			viper.Set("KeysDirectory", t.TempDir())
+
+			provider := &fake.Provider{
+				EC2Clients: map[string]*fake.EC2{
+					"us-east-1": {KeyPairs: []*ec2.KeyPairInfo{{KeyName: aws.String("prod"), KeyFingerprint: aws.String(tt.fingerprint)}}},
+				},
+			}
+
+			instance := &inst.Instance{InstanceId: "i-0123", KeyName: "prod", Region: "us-east-1"}
+
+			key, err := PromptKey(provider, instance, NewKeyCache(GetCachePath().Path))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if key != "" {
+				t.Errorf("PromptKey() = %q, expected no key", key)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package ssh
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/utils"
+	"github.com/spf13/pflag"
+)
+
+// Bastion is a resolved jump host, reached by Conn at Target.
+type Bastion struct {
+	Instance  inst.Instance
+	Conn      string
+	Target    string
+	LoginName string
+	Key       string
+}
+
+// Resolved bastions are kept for the process, exec resolves one for every target
+var (
+	bastionsMu sync.Mutex
+	bastions   = map[string]*Bastion{}
+)
+
+func newRuleSelector(query string, filters []string, flag string) (*Selector, error) {
+	tags, fields, err := parseFilters(filters, flag)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Selector{Query: query, Tags: tags, Filters: fields, explicit: true}, nil
+}
+
+// matchBastionRule returns the index of the first rule matching the instance, or -1.
+func matchBastionRule(rules []config.BastionRule, instance *inst.Instance) (int, error) {
+	for idx, rule := range rules {
+		selector, err := newRuleSelector("", rule.Match, "Bastions.Match")
+
+		if err != nil {
+			return -1, err
+		}
+
+		if selector.Match(*instance) {
+			return idx, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// selectBastionConnection follows the connection order, skipping private addresses which would need a bastion themselves.
+func selectBastionConnection(bastion *inst.Instance) (string, string, error) {
+	conns, err := config.GetConnectionOrder()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, conn := range conns {
+		if IsPrivateConnection(conn) {
+			continue
+		}
+
+		address, _, err := resolveAddress(conn, bastion)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		if address != "" {
+			return conn, address, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("%w for bastion %s, it needs a public address or SSM", ErrNoTarget, bastion.InstanceId)
+}
+
+// findKey looks for an instance's key in the cache, then by its key pair, leaving ssh to its defaults otherwise.
+func findKey(clients inst.ClientProvider, instance *inst.Instance) string {
+	cachepath := GetCachePath()
+
+	if key, found := NewKeyCache(cachepath.Path).Check(instance); found {
+		return key
+	}
+
+	keysDir, err := config.GetKeysDirectory()
+
+	if err != nil {
+		return ""
+	}
+
+	keys, _ := GetKeys(keysDir)
+
+	if key := MatchKey(clients, instance, keysDir, keys); key != "" {
+		return filepath.Join(keysDir, key)
+	}
+
+	return ""
+}
+
+// findBastion picks a reachable, running bastion matching the rule from the instance's profile and region, preferring its VPC.
+func findBastion(clients inst.ClientProvider, rule config.BastionRule, instance *inst.Instance) (*inst.Instance, error) {
+	selector, err := newRuleSelector(rule.Bastion, rule.Filters, "Bastions.Filters")
+
+	if err != nil {
+		return nil, err
+	}
+
+	scope, err := inst.NewScope(instance.Profile, instance.Region)
+
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := GetInstances(&GetInstancesInput{
+		Clients: clients,
+		Scopes:  []*inst.Scope{scope},
+		SSM:     config.GetSSMEnabled(),
+		Filters: selector.EC2Filters(),
+		Filter: func(candidate inst.Instance) bool {
+			if candidate.State != "running" || candidate.InstanceId == instance.InstanceId || !selector.Match(candidate) {
+				return false
+			}
+
+			_, _, err := selectBastionConnection(&candidate)
+
+			return err == nil
+		},
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("No bastion matching [%s] for %s: %w", rule.Bastion, instance.InstanceId, err)
+	}
+
+	for _, candidate := range candidates {
+		if candidate.VpcId == instance.VpcId {
+			return &candidate, nil
+		}
+	}
+
+	return &candidates[0], nil
+}
+
+// GetBastion resolves the bastion for an instance from the configured rules, nil when no rule matches.
+func GetBastion(clients inst.ClientProvider, instance *inst.Instance) (*Bastion, error) {
+	rules, err := config.GetBastions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := matchBastionRule(rules, instance)
+
+	if err != nil || idx < 0 {
+		return nil, err
+	}
+
+	cacheKey := fmt.Sprintf("%d/%s/%s/%s", idx, instance.Profile, instance.Region, instance.VpcId)
+
+	bastionsMu.Lock()
+	defer bastionsMu.Unlock()
+
+	if bastion, found := bastions[cacheKey]; found {
+		return bastion, nil
+	}
+
+	rule := rules[idx]
+
+	found, err := findBastion(clients, rule, instance)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, target, err := selectBastionConnection(found)
+
+	if err != nil {
+		return nil, err
+	}
+
+	loginName := rule.LoginName
+
+	if loginName == "" {
+		if loginName, _, err = inferLoginName(clients, getImageCache(), found); err != nil {
+			return nil, err
+		}
+	}
+
+	if loginName == "" {
+		if loginName, err = config.GetDefaultUser(); err != nil {
+			return nil, err
+		}
+	}
+
+	bastion := &Bastion{
+		Instance:  *found,
+		Conn:      conn,
+		Target:    target,
+		LoginName: loginName,
+		Key:       findKey(clients, found),
+	}
+
+	bastions[cacheKey] = bastion
+
+	return bastion, nil
+}
+
+// escapeTokens keeps ssh from expanding %h and %p meant for a nested command.
+func escapeTokens(command string) string {
+	return strings.ReplaceAll(command, "%", "%%")
+}
+
+// Args returns the ssh arguments hopping through the bastion. A plain ProxyJump is enough without a key or SSM,
+// otherwise ssh runs as the ProxyCommand to pass them along.
+func (b *Bastion) Args() ([]string, error) {
+	if b.Conn != "SSM" && b.Key == "" {
+		return []string{"-J", fmt.Sprintf("%s@%s", b.LoginName, bracketIPv6(b.Target))}, nil
+	}
+
+	components := []string{"ssh"}
+
+	if b.Conn == "SSM" {
+		proxy, err := ssmProxyCommand(b.Instance.Profile, b.Instance.Region)
+
+		if err != nil {
+			return nil, err
+		}
+
+		components = append(components, "-o", fmt.Sprintf("ProxyCommand=%s", escapeTokens(proxy)))
+	}
+
+	components = append(components, GetKey(b.Key)...)
+	components = append(components, "-l", b.LoginName, "-W", "%h:%p", b.Target)
+
+	return []string{"-o", fmt.Sprintf("ProxyCommand=%s", utils.ShellJoin(components))}, nil
+}
+
+// hasProxyOption reports whether the user routes the connection themselves, -o ProxyJump=none skips bastions.
+func hasProxyOption(flags *pflag.FlagSet) bool {
+	opts, _ := flags.GetStringSlice("option")
+
+	for _, opt := range opts {
+		fields := strings.FieldsFunc(opt, func(r rune) bool { return r == '=' || unicode.IsSpace(r) })
+
+		if len(fields) > 0 && (strings.EqualFold(fields[0], "ProxyJump") || strings.EqualFold(fields[0], "ProxyCommand")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getBastionProxy(flags *pflag.FlagSet, instance *inst.Instance) ([]string, error) {
+	if hasProxyOption(flags) {
+		return []string{}, nil
+	}
+
+	bastion, err := GetBastion(inst.DefaultClients, instance)
+
+	if err != nil || bastion == nil {
+		return []string{}, err
+	}
+
+	return bastion.Args()
+}
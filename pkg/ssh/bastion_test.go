@@ -0,0 +1,148 @@
+package ssh
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/instances/fake"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/viper"
+)
+
+func setupBastions(t *testing.T, rules []config.BastionRule) {
+	t.Helper()
+
+	setupConfig(t)
+
+	viper.Set("Bastions", rules)
+
+	t.Cleanup(func() { bastions = map[string]*Bastion{} })
+}
+
+func newBastionInstance(id string, vpc string, publicIp string) *ec2.Instance {
+	instance := newEC2Instance(id, "bastion-"+vpc, "running")
+
+	instance.VpcId = aws.String(vpc)
+
+	if publicIp != "" {
+		instance.PublicIpAddress = aws.String(publicIp)
+	}
+
+	return instance
+}
+
+func TestMatchBastionRule(t *testing.T) {
+	rules := []config.BastionRule{
+		{Match: []string{"vpc-id=vpc-a"}, Bastion: "bastion-a"},
+		{Match: []string{"tag:Env=prod", "subnet-id=subnet-1*"}, Bastion: "bastion-prod"},
+		{Bastion: "bastion-*"},
+	}
+
+	tests := []struct {
+		name     string
+		instance inst.Instance
+		expected int
+	}{
+		{"vpc", inst.Instance{VpcId: "vpc-a"}, 0},
+		{"tag and subnet", inst.Instance{VpcId: "vpc-b", SubnetId: "subnet-12", Tags: map[string]string{"Env": "prod"}}, 1},
+		{"catch all", inst.Instance{VpcId: "vpc-b", SubnetId: "subnet-2", Tags: map[string]string{"Env": "prod"}}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, err := matchBastionRule(rules, &tt.instance)
+
+			if err != nil || idx != tt.expected {
+				t.Errorf("matchBastionRule() = %d, %v, expected %d", idx, err, tt.expected)
+			}
+		})
+	}
+
+	if idx, _ := matchBastionRule(rules[:2], &inst.Instance{VpcId: "vpc-c"}); idx != -1 {
+		t.Errorf("matchBastionRule() = %d, expected no match", idx)
+	}
+}
+
+func TestGetBastion(t *testing.T) {
+	setupBastions(t, []config.BastionRule{{Match: []string{"vpc-id=vpc-a"}, Bastion: "bastion-*", LoginName: "jump"}})
+
+	provider := &fake.Provider{
+		EC2Clients: map[string]*fake.EC2{
+			"us-east-1": {Instances: []*ec2.Instance{
+				newBastionInstance("i-other", "vpc-b", "3.3.3.3"),
+				newBastionInstance("i-private", "vpc-a", ""),
+				newBastionInstance("i-bastion", "vpc-a", "1.1.1.1"),
+			}},
+		},
+	}
+
+	target := &inst.Instance{InstanceId: "i-target", VpcId: "vpc-a", Region: "us-east-1"}
+
+	bastion, err := GetBastion(provider, target)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// i-private has no public IP or SSM, i-other is in another VPC
+	if bastion.Instance.InstanceId != "i-bastion" || bastion.Conn != "PUBLIC" || bastion.Target != "1.1.1.1" || bastion.LoginName != "jump" {
+		t.Fatalf("GetBastion() = %+v", bastion)
+	}
+
+	args, err := bastion.Args()
+
+	if err != nil || !reflect.DeepEqual(args, []string{"-J", "jump@1.1.1.1"}) {
+		t.Errorf("Args() = %v, %v", args, err)
+	}
+
+	if other, _ := GetBastion(provider, &inst.Instance{InstanceId: "i-2", VpcId: "vpc-b", Region: "us-east-1"}); other != nil {
+		t.Errorf("GetBastion() = %+v, expected no rule to match", other)
+	}
+}
+
+func TestBastionArgsWithKey(t *testing.T) {
+	setupConfig(t)
+
+	bastion := &Bastion{Conn: "PUBLIC", Target: "1.1.1.1", LoginName: "ec2-user", Key: "/keys/bastion.pem"}
+
+	args, err := bastion.Args()
+
+	expected := []string{"-o", "ProxyCommand=ssh -i /keys/bastion.pem -l ec2-user -W %h:%p 1.1.1.1"}
+
+	if err != nil || !reflect.DeepEqual(args, expected) {
+		t.Errorf("Args() = %v, %v, expected %v", args, err, expected)
+	}
+}
+
+func TestBastionArgsIPv6(t *testing.T) {
+	bastion := &Bastion{Conn: "IPV6", Target: "2600:1f18::1", LoginName: "ec2-user"}
+
+	args, err := bastion.Args()
+
+	if expected := []string{"-J", "ec2-user@[2600:1f18::1]"}; err != nil || !reflect.DeepEqual(args, expected) {
+		t.Errorf("Args() = %v, %v, expected %v", args, err, expected)
+	}
+}
+
+func TestEscapeTokens(t *testing.T) {
+	if escaped := escapeTokens("aws ssm start-session --target %h --parameters portNumber=%p"); escaped != "aws ssm start-session --target %%h --parameters portNumber=%%p" {
+		t.Errorf("escapeTokens() = %s", escaped)
+	}
+}
+
+func TestHasProxyOption(t *testing.T) {
+	tests := map[string]bool{
+		"ProxyJump=none":           true,
+		"proxycommand ssh -W":      true,
+		"StrictHostKeyChecking=no": false,
+	}
+
+	for opt, expected := range tests {
+		if actual := hasProxyOption(newFlags(t, "-o", opt)); actual != expected {
+			t.Errorf("hasProxyOption(%q) = %v, expected %v", opt, actual, expected)
+		}
+	}
+}
@@ -2,26 +2,50 @@ package ssh
 
 import (
 	"fmt"
-	"log"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	inst "github.com/JFenstermacher/awssh/pkg/instances"
 	"github.com/JFenstermacher/awssh/pkg/utils"
+	"github.com/gofrs/flock"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
 )
 
+// KeyCache remembers the keys that logged into instances. Instance IDs and key pair names are only unique
+// within an account and region, so entries are namespaced by both.
 type KeyCache struct {
-	cache *viper.Viper
+	path       string
+	mu         sync.Mutex
+	Namespaces map[string]*KeyNamespace
 }
 
+// KeyNamespace holds the keys of one account and region, by instance ID and by key pair name.
+type KeyNamespace struct {
+	Instances map[string]*KeyEntry
+	KeyPairs  map[string]*KeyEntry
+}
+
+// KeyEntry is a saved key, Hash is the SHA-256 of the file so a replaced key is no longer trusted.
 type KeyEntry struct {
 	Location string
 	Hash     string
 }
 
+func (e *KeyEntry) valid() bool {
+	if e == nil {
+		return false
+	}
+
+	hash, err := utils.HashFile(e.Location)
+
+	return err == nil && hash == e.Hash
+}
+
 type CachePath struct {
 	Dir  string
 	Ext  string
@@ -43,76 +67,151 @@ func GetCachePath() *CachePath {
 }
 
 func NewKeyCache(cachepath string) *KeyCache {
-	cache := viper.New()
+	cache := &KeyCache{path: cachepath}
 
-	dir, file := path.Split(cachepath)
-	ext := filepath.Ext(file)
+	cache.read()
 
-	cache.AddConfigPath(dir)
-	cache.SetConfigType(ext)
-	cache.SetConfigName(file[:len(file)-len(ext)])
+	return cache
+}
+
+func (kc *KeyCache) read() {
+	if !readCacheFile(kc.path, kc) || kc.Namespaces == nil {
+		kc.Namespaces = map[string]*KeyNamespace{}
+	}
+}
 
-	cache.ReadInConfig()
+// readCacheFile loads a YAML cache into v, reporting whether it could. A missing, corrupt or outdated cache
+// is only a cache miss, callers start over with an empty one.
+func readCacheFile(path string, v interface{}) bool {
+	data, err := ioutil.ReadFile(path)
 
-	return &KeyCache{cache: cache}
+	if err != nil {
+		return false
+	}
+
+	return yaml.Unmarshal(data, v) == nil
 }
 
-func (kc *KeyCache) Check(id string) (string, bool) {
-	sub := kc.cache.Sub(id)
+// getNamespace keys entries by account and region. Instances listed before accounts were recorded fall back to their profile.
+func getNamespace(instance *inst.Instance) string {
+	account := instance.AccountId
 
-	if sub == nil {
-		return "", false
+	if account == "" {
+		account = instance.Profile
 	}
 
-	var entry KeyEntry
+	return fmt.Sprintf("%s/%s", account, instance.Region)
+}
 
-	sub.Unmarshal(&entry)
+// Check returns the key saved for the instance, or else for its key pair, as long as the key file is unchanged.
+func (kc *KeyCache) Check(instance *inst.Instance) (string, bool) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
 
-	hash, err := utils.HashFile(entry.Location)
+	namespace, found := kc.Namespaces[getNamespace(instance)]
 
-	if err != nil || hash != entry.Hash {
+	if !found || namespace == nil {
 		return "", false
 	}
 
-	return entry.Location, true
+	if entry := namespace.Instances[instance.InstanceId]; entry.valid() {
+		return entry.Location, true
+	}
+
+	if entry := namespace.KeyPairs[instance.KeyName]; instance.KeyName != "" && entry.valid() {
+		return entry.Location, true
+	}
+
+	return "", false
 }
 
-func expandPath(keypath string) string {
+func expandPath(keypath string) (string, error) {
 	// Assume this is an absolute path
 	if path.IsAbs(keypath) {
-		return keypath
+		return keypath, nil
 	}
 
 	home := viper.GetString("HOME")
 
 	if strings.HasPrefix(keypath, "~") {
-		return filepath.Join(home, keypath[1:])
+		return filepath.Join(home, keypath[1:]), nil
 	}
 
 	currdir, _ := os.Getwd()
 
-	path, err := filepath.Abs(filepath.Join(currdir, keypath))
+	return filepath.Abs(filepath.Join(currdir, keypath))
+}
 
-	if err != nil {
-		log.Fatal(err)
+// Save remembers the key that logged into the instance and its key pair. Agent identities have no key file and aren't saved.
+// Other awssh processes may save at the same time, so the cache is read again and replaced while holding a file lock.
+func (kc *KeyCache) Save(instance *inst.Instance, keypath string) error {
+	if keypath == "" {
+		return nil
 	}
 
-	return path
-}
-
-func (kc *KeyCache) Save(instance *inst.Instance, keypath string) {
 	hash, err := utils.HashFile(keypath)
 
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	location, err := expandPath(keypath)
+
+	if err != nil {
+		return err
 	}
 
-	kc.cache.Set(fmt.Sprintf("%s.%s", instance.InstanceId, "Location"), expandPath(keypath))
-	kc.cache.Set(fmt.Sprintf("%s.%s", instance.InstanceId, "Hash"), hash)
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
 
-	configpath := GetCachePath()
+	if err := os.MkdirAll(filepath.Dir(kc.path), 0755); err != nil {
+		return err
+	}
+
+	lock := flock.New(kc.path + ".lock")
+
+	if err := lock.Lock(); err != nil {
+		return err
+	}
 
-	os.Mkdir(configpath.Dir, 0755)
+	defer lock.Unlock()
+
+	kc.read()
+
+	key := getNamespace(instance)
+	namespace := kc.Namespaces[key]
+
+	if namespace == nil {
+		namespace = &KeyNamespace{}
+		kc.Namespaces[key] = namespace
+	}
+
+	if namespace.Instances == nil {
+		namespace.Instances = map[string]*KeyEntry{}
+	}
+
+	if namespace.KeyPairs == nil {
+		namespace.KeyPairs = map[string]*KeyEntry{}
+	}
+
+	entry := &KeyEntry{Location: location, Hash: hash}
+
+	namespace.Instances[instance.InstanceId] = entry
+
+	if instance.KeyName != "" {
+		namespace.KeyPairs[instance.KeyName] = entry
+	}
+
+	return kc.write()
+}
+
+func (kc *KeyCache) write() error {
+	data, err := yaml.Marshal(kc)
+
+	if err != nil {
+		return err
+	}
 
-	kc.cache.WriteConfigAs(configpath.Path)
+	// Key locations stay private to the user
+	return utils.WriteFileAtomic(kc.path, data, 0600)
 }
@@ -0,0 +1,104 @@
+package ssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+)
+
+func TestKeyCache(t *testing.T) {
+	home := setupConfig(t)
+
+	keypath := filepath.Join(home, "deploy.pem")
+	writeKeys(t, home, "deploy.pem")
+
+	instance := &inst.Instance{InstanceId: "i-0123", KeyName: "deploy", AccountId: "111122223333", Region: "us-east-1"}
+
+	if err := NewKeyCache(GetCachePath().Path).Save(instance, keypath); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewKeyCache(GetCachePath().Path)
+
+	if actual, found := cache.Check(instance); !found || actual != keypath {
+		t.Fatalf("Check() = %q, %t, expected %q", actual, found, keypath)
+	}
+
+	// A new instance of the autoscaling group shares the key pair
+	scaled := &inst.Instance{InstanceId: "i-4567", KeyName: "deploy", AccountId: "111122223333", Region: "us-east-1"}
+
+	if actual, found := cache.Check(scaled); !found || actual != keypath {
+		t.Errorf("Check() = %q, %t, expected the key pair's key", actual, found)
+	}
+
+	misses := map[string]*inst.Instance{
+		"unknown":       {InstanceId: "i-89ab", AccountId: "111122223333", Region: "us-east-1"},
+		"other key":     {InstanceId: "i-89ab", KeyName: "other", AccountId: "111122223333", Region: "us-east-1"},
+		"other account": {InstanceId: "i-0123", KeyName: "deploy", AccountId: "444455556666", Region: "us-east-1"},
+		"other region":  {InstanceId: "i-0123", KeyName: "deploy", AccountId: "111122223333", Region: "eu-west-1"},
+	}
+
+	for name, miss := range misses {
+		if _, found := cache.Check(miss); found {
+			t.Errorf("expected %s to miss", name)
+		}
+	}
+
+	if err := ioutil.WriteFile(keypath, []byte("rotated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := cache.Check(instance); found {
+		t.Errorf("expected changed key file to miss")
+	}
+}
+
+func TestKeyCacheConcurrentSaves(t *testing.T) {
+	home := setupConfig(t)
+
+	writeKeys(t, home, "deploy.pem")
+
+	var wg sync.WaitGroup
+
+	// Each cache reads the file before any save, like separate awssh processes
+	for i := 0; i < 10; i++ {
+		cache := NewKeyCache(GetCachePath().Path)
+		instance := &inst.Instance{InstanceId: fmt.Sprintf("i-%d", i), Region: "us-east-1"}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := cache.Save(instance, filepath.Join(home, "deploy.pem")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	cache := NewKeyCache(GetCachePath().Path)
+
+	for i := 0; i < 10; i++ {
+		if _, found := cache.Check(&inst.Instance{InstanceId: fmt.Sprintf("i-%d", i), Region: "us-east-1"}); !found {
+			t.Errorf("expected i-%d to be saved", i)
+		}
+	}
+
+	info, err := os.Stat(GetCachePath().Path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("cache mode = %v, expected 0600", info.Mode().Perm())
+	}
+}
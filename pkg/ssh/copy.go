@@ -0,0 +1,178 @@
+package ssh
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/utils"
+	"github.com/spf13/pflag"
+)
+
+type CopyPath struct {
+	Host   string
+	Path   string
+	Remote bool
+}
+
+// ParseCopyPath treats [instance]:path as remote, so long as no '/' precedes the ':'.
+func ParseCopyPath(arg string) CopyPath {
+	idx := strings.Index(arg, ":")
+
+	if idx < 0 || strings.Contains(arg[:idx], "/") {
+		return CopyPath{Path: arg}
+	}
+
+	return CopyPath{Host: arg[:idx], Path: arg[idx+1:], Remote: true}
+}
+
+func ValidateCopyPaths(src CopyPath, dst CopyPath) error {
+	if src.Remote == dst.Remote {
+		return usageError("Exactly one of source or destination must be remote, prefix the remote path with ':' or '{instance}:'")
+	}
+
+	return nil
+}
+
+func GetRemoteHost(src CopyPath, dst CopyPath) string {
+	if src.Remote {
+		return src.Host
+	}
+
+	return dst.Host
+}
+
+func formatRemotePath(user string, host string, path string) string {
+	return fmt.Sprintf("%s@%s:%s", user, bracketIPv6(host), path)
+}
+
+func resolveCopyPath(flags *pflag.FlagSet, instance *inst.Instance, cp CopyPath) (string, error) {
+	if !cp.Remote {
+		return cp.Path, nil
+	}
+
+	user, err := getLoginName(flags, instance)
+
+	if err != nil {
+		return "", err
+	}
+
+	target, err := GetTarget(flags, instance)
+
+	if err != nil {
+		return "", err
+	}
+
+	return formatRemotePath(user, target, cp.Path), nil
+}
+
+func resolveCopyPaths(flags *pflag.FlagSet, instance *inst.Instance, src CopyPath, dst CopyPath) ([]string, error) {
+	paths := []string{}
+
+	for _, cp := range []CopyPath{src, dst} {
+		path, err := resolveCopyPath(flags, instance, cp)
+
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+func generateScpCmd(flags *pflag.FlagSet, instance *inst.Instance, key string, src CopyPath, dst CopyPath) (string, []string, error) {
+	cmd := "scp"
+
+	proxy, err := GetProxyCommand(flags, instance)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	paths, err := resolveCopyPaths(flags, instance, src, dst)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	components := GetBaseFlags()
+	components = append(components, GetOptions(flags)...)
+	components = append(components, proxy...)
+	components = append(components, GetKey(key)...)
+	components = append(components, "-P", getPort(flags))
+
+	if recursive, _ := flags.GetBool("recursive"); recursive {
+		components = append(components, "-r")
+	}
+
+	components = append(components, paths...)
+
+	return cmd, components, nil
+}
+
+func generateRsyncCmd(flags *pflag.FlagSet, instance *inst.Instance, key string, src CopyPath, dst CopyPath) (string, []string, error) {
+	cmd := "rsync"
+
+	proxy, err := GetProxyCommand(flags, instance)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	paths, err := resolveCopyPaths(flags, instance, src, dst)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	// BaseFlags is a raw string of flags, so only the remaining components are quoted
+	transport := append([]string{"ssh"}, GetBaseFlags()...)
+
+	components := []string{}
+	components = append(components, GetOptions(flags)...)
+	components = append(components, proxy...)
+	components = append(components, GetKey(key)...)
+	components = append(components, GetPort(flags)...)
+
+	transport = append(transport, utils.ShellJoin(components))
+
+	components = []string{"-e", strings.Join(transport, " ")}
+
+	if recursive, _ := flags.GetBool("recursive"); recursive {
+		components = append(components, "--recursive")
+	}
+
+	components = append(components, paths...)
+
+	return cmd, components, nil
+}
+
+func generateCopyCmd(flags *pflag.FlagSet, instance *inst.Instance, key string, src CopyPath, dst CopyPath) (string, []string, error) {
+	if rsync, _ := flags.GetBool("rsync"); rsync {
+		return generateRsyncCmd(flags, instance, key, src, dst)
+	}
+
+	return generateScpCmd(flags, instance, key, src, dst)
+}
+
+func Copy(flags *pflag.FlagSet, instance *inst.Instance, key string, src CopyPath, dst CopyPath) error {
+	base, components, err := generateCopyCmd(flags, instance, key, src, dst)
+
+	if err != nil {
+		return err
+	}
+
+	log.Println(base, strings.Join(components, " "))
+
+	if dryRun, _ := flags.GetBool("dryRun"); dryRun {
+		explainConnection(flags, instance)
+
+		return nil
+	}
+
+	return runCommand(exec.Command(base, components...))
+}
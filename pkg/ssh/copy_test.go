@@ -0,0 +1,150 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/spf13/viper"
+)
+
+func TestParseCopyPath(t *testing.T) {
+	tests := []struct {
+		arg      string
+		expected CopyPath
+	}{
+		{"web-1:/var/log", CopyPath{Host: "web-1", Path: "/var/log", Remote: true}},
+		{":/var/log", CopyPath{Path: "/var/log", Remote: true}},
+		{"web-1:", CopyPath{Host: "web-1", Remote: true}},
+		{"./a:b", CopyPath{Path: "./a:b"}},
+		{"/tmp/a:b", CopyPath{Path: "/tmp/a:b"}},
+		{"notes.txt", CopyPath{Path: "notes.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			if actual := ParseCopyPath(tt.arg); actual != tt.expected {
+				t.Errorf("ParseCopyPath(%q) = %+v, expected %+v", tt.arg, actual, tt.expected)
+			}
+		})
+	}
+}
+
+// fakeSSMTools puts session-manager-plugin and the AWS CLI on PATH, so SSM connections build an aws ProxyCommand.
+func fakeSSMTools(t *testing.T) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools require a POSIX shell")
+	}
+
+	dir := t.TempDir()
+
+	for _, name := range []string{"session-manager-plugin", "aws"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestGenerateCopyCmd(t *testing.T) {
+	public := &inst.Instance{InstanceId: "i-public", PublicIpAddress: "54.1.2.3"}
+	ipv6 := &inst.Instance{InstanceId: "i-ipv6", NetworkInterfaces: []inst.NetworkInterface{{Ipv6Addresses: []string{"2600:1f18::1"}}}}
+	ssm := &inst.Instance{InstanceId: "i-ssm", Profile: "dev", Region: "us-east-1", SSMEnabled: true}
+
+	upload := []CopyPath{{Path: "./site"}, {Path: "/srv/site", Remote: true}}
+	download := []CopyPath{{Path: "/var/log/app.log", Remote: true}, {Path: "."}}
+
+	proxy := "ProxyCommand=aws ssm start-session --target %h --document-name AWS-StartSSHSession --parameters portNumber=%p --profile dev --region us-east-1"
+
+	tests := []struct {
+		name     string
+		order    []string
+		args     []string
+		instance *inst.Instance
+		paths    []CopyPath
+		cmd      string
+		expected []string
+	}{
+		{
+			name:     "scp",
+			order:    []string{"PUBLIC"},
+			args:     []string{"-l", "ec2-user", "-p", "2222", "-r"},
+			instance: public,
+			paths:    upload,
+			cmd:      "scp",
+			expected: []string{"-q", "-i", "/keys/id", "-P", "2222", "-r", "./site", "ec2-user@54.1.2.3:/srv/site"},
+		},
+		{
+			name:     "scp ipv6",
+			order:    []string{"IPV6"},
+			args:     []string{"-l", "ec2-user"},
+			instance: ipv6,
+			paths:    download,
+			cmd:      "scp",
+			expected: []string{"-q", "-i", "/keys/id", "-P", "22", "ec2-user@[2600:1f18::1]:/var/log/app.log", "."},
+		},
+		{
+			name:     "rsync",
+			order:    []string{"PUBLIC"},
+			args:     []string{"-l", "ec2-user", "--rsync", "-r", "-o", "ConnectTimeout=5"},
+			instance: public,
+			paths:    upload,
+			cmd:      "rsync",
+			expected: []string{"-e", "ssh -q -o ConnectTimeout=5 -i /keys/id -p 22", "--recursive", "./site", "ec2-user@54.1.2.3:/srv/site"},
+		},
+		{
+			name:     "rsync ipv6",
+			order:    []string{"IPV6"},
+			args:     []string{"-l", "ec2-user", "--rsync"},
+			instance: ipv6,
+			paths:    download,
+			cmd:      "rsync",
+			expected: []string{"-e", "ssh -q -i /keys/id -p 22", "ec2-user@[2600:1f18::1]:/var/log/app.log", "."},
+		},
+		{
+			name:     "rsync quotes the ProxyCommand",
+			order:    []string{"SSM"},
+			args:     []string{"-l", "ec2-user", "--rsync"},
+			instance: ssm,
+			paths:    upload,
+			cmd:      "rsync",
+			expected: []string{"-e", "ssh -q -o '" + proxy + "' -i /keys/id -p 22", "./site", "ec2-user@i-ssm:/srv/site"},
+		},
+		{
+			name:     "scp passes the ProxyCommand as one argument",
+			order:    []string{"SSM"},
+			args:     []string{"-l", "ec2-user"},
+			instance: ssm,
+			paths:    download,
+			cmd:      "scp",
+			expected: []string{"-q", "-o", proxy, "-i", "/keys/id", "-P", "22", "ec2-user@i-ssm:/var/log/app.log", "."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfig(t)
+			fakeSSMTools(t)
+
+			viper.Set("ConnectionOrder", tt.order)
+			viper.Set("BaseFlags", "-q")
+
+			cmd, components, err := generateCopyCmd(newFlags(t, tt.args...), tt.instance, "/keys/id", tt.paths[0], tt.paths[1])
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cmd != tt.cmd || !reflect.DeepEqual(components, tt.expected) {
+				t.Errorf("generateCopyCmd() = %s %q, expected %s %q", cmd, components, tt.cmd, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package ssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/gofrs/flock"
+	"github.com/spf13/pflag"
+)
+
+var (
+	// Parallel exec pushes the key from every worker, but it must only be generated once
+	eicKeyMu sync.Mutex
+
+	// sendSSHPublicKey is swapped out in tests, which can't reach EC2 Instance Connect
+	sendSSHPublicKey = inst.SendSSHPublicKey
+)
+
+func UseEIC(flags *pflag.FlagSet) bool {
+	if eic, _ := flags.GetBool("eic"); eic {
+		return true
+	}
+
+	if key, _ := flags.GetString("identityFile"); key != "" {
+		return false
+	}
+
+	return config.GetEICEnabled()
+}
+
+func GetEICKeyPath() string {
+	cachepath := GetCachePath()
+
+	return filepath.Join(cachepath.Dir, "eic", "id_rsa")
+}
+
+// ensureEICKey reuses the local ephemeral key, generating it on first use. Generation is serialized within the process
+// and with other awssh runs, as ssh-keygen would otherwise prompt to overwrite a key created in the meantime.
+// RSA is used as the SDK rejects public keys shorter than 256 characters.
+func ensureEICKey(keypath string) error {
+	eicKeyMu.Lock()
+	defer eicKeyMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(keypath), 0700); err != nil {
+		return err
+	}
+
+	lock := flock.New(keypath + ".lock")
+
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+
+	defer lock.Unlock()
+
+	if _, err := os.Stat(keypath); err == nil {
+		return nil
+	}
+
+	cmd := exec.Command("ssh-keygen", "-q", "-t", "rsa", "-b", "4096", "-N", "", "-C", "awssh-eic", "-f", keypath)
+
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// PushEICKey sends the ephemeral public key for the login user, which is then valid for 60 seconds.
+// Under --dryRun nothing is generated or pushed, only what would be pushed is printed.
+func PushEICKey(flags *pflag.FlagSet, instance *inst.Instance) (string, error) {
+	keypath := GetEICKeyPath()
+
+	user, err := getLoginName(flags, instance)
+
+	if err != nil {
+		return "", err
+	}
+
+	if dryRun, _ := flags.GetBool("dryRun"); dryRun {
+		fmt.Fprintf(os.Stderr, "Would push %s.pub for %s to %s via EC2 Instance Connect\n", keypath, user, instance.InstanceId)
+
+		return keypath, nil
+	}
+
+	if err := ensureEICKey(keypath); err != nil {
+		return "", err
+	}
+
+	publicKey, err := ioutil.ReadFile(keypath + ".pub")
+
+	if err != nil {
+		return "", err
+	}
+
+	session, err := inst.GetSession(instance.Profile, instance.Region)
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := sendSSHPublicKey(session, instance, user, strings.TrimSpace(string(publicKey))); err != nil {
+		return "", err
+	}
+
+	return keypath, nil
+}
@@ -0,0 +1,124 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestPushEICKeyDryRun(t *testing.T) {
+	setupConfig(t)
+
+	instance := &inst.Instance{InstanceId: "i-0123", Region: "us-east-1"}
+
+	key, err := PushEICKey(newFlags(t, "--dryRun", "-l", "ec2-user"), instance)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != GetEICKeyPath() {
+		t.Errorf("PushEICKey() = %q, expected %q", key, GetEICKeyPath())
+	}
+
+	if _, err := os.Stat(key); !os.IsNotExist(err) {
+		t.Errorf("expected no key to be generated, got %v", err)
+	}
+}
+
+// fakeSSHKeygen puts an ssh-keygen on PATH that slowly writes a key, failing like the real one when it already exists.
+// It returns the file every invocation is logged to.
+func fakeSSHKeygen(t *testing.T) string {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ssh-keygen requires a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	log := filepath.Join(dir, "invocations")
+
+	script := `#!/bin/sh
+while [ $# -gt 1 ]; do
+	[ "$1" = "-f" ] && key="$2"
+	shift
+done
+echo "$key" >> "$KEYGEN_LOG"
+if [ -e "$key" ]; then
+	echo "$key already exists." >&2
+	exit 1
+fi
+sleep 0.2
+echo private > "$key"
+echo "ssh-rsa AAAA awssh-eic" > "$key.pub"
+`
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "ssh-keygen"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("KEYGEN_LOG", log)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return log
+}
+
+func TestRunRemoteParallelGeneratesEICKeyOnce(t *testing.T) {
+	setupConfig(t)
+	fakeSSH(t)
+	captureOutput(t)
+
+	log := fakeSSHKeygen(t)
+
+	var mu sync.Mutex
+	pushed := []string{}
+
+	send := sendSSHPublicKey
+	sendSSHPublicKey = func(sess *session.Session, instance *inst.Instance, user string, publicKey string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		pushed = append(pushed, instance.InstanceId)
+
+		return nil
+	}
+
+	t.Cleanup(func() { sendSSHPublicKey = send })
+
+	targets := []RemoteTarget{}
+
+	for _, id := range []string{"i-a", "i-b", "i-c", "i-d"} {
+		instance := &inst.Instance{InstanceId: id, PrivateIpAddress: "10.0.0.1", Region: "us-east-1"}
+
+		targets = append(targets, RemoteTarget{Instance: instance, EIC: true})
+	}
+
+	results, err := RunRemoteParallel(newFlags(t, "-l", "ec2-user"), targets, []string{"uptime"}, nil, len(targets))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range results {
+		if result.Err != nil || result.Code != 0 || result.Target.Key != GetEICKeyPath() {
+			t.Errorf("%s failed with %d: %v", result.Target.Instance.InstanceId, result.Code, result.Err)
+		}
+	}
+
+	invocations, _ := ioutil.ReadFile(log)
+
+	if count := strings.Count(string(invocations), "\n"); count != 1 {
+		t.Errorf("expected ssh-keygen to run once, ran %d times", count)
+	}
+
+	if len(pushed) != len(targets) {
+		t.Errorf("expected the key to be pushed to every instance, pushed to %v", pushed)
+	}
+}
@@ -0,0 +1,21 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoInstances  = errors.New("No instances found")
+	ErrNoKeys       = errors.New("No keys available")
+	ErrNoTarget     = errors.New("No address to connect to")
+	ErrNotRunning   = errors.New("Instance is not running")
+	ErrRemoteFailed = errors.New("Command failed")
+	ErrInterrupted  = errors.New("Interrupted")
+	ErrUsage        = errors.New("Invalid usage")
+)
+
+// usageError describes flags or arguments that can't be used together or parsed.
+func usageError(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrUsage, fmt.Sprintf(format, a...))
+}
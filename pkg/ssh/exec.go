@@ -0,0 +1,98 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// sshErrorStatus is the status ssh exits with on its own errors, such as failing to connect or authenticate.
+const sshErrorStatus = 255
+
+// runCommand runs cmd attached to the terminal, forwarding signals awssh receives to it while it runs.
+// Streams already set on cmd are kept.
+func runCommand(cmd *exec.Cmd) error {
+	if cmd.Stdin == nil {
+		cmd.Stdin = os.Stdin
+	}
+
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+
+	signals := make(chan os.Signal, 1)
+
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+
+	close(done)
+
+	return err
+}
+
+// RemoteExitError carries the exit status of a command that didn't run as a local process, such as through SSM.
+type RemoteExitError struct {
+	Code int
+}
+
+func (e *RemoteExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode reports the status a child process exited with, following the shell convention of 128+n when killed by a signal.
+func ExitCode(err error) (int, bool) {
+	var remoteErr *RemoteExitError
+
+	if errors.As(err, &remoteErr) {
+		return remoteErr.Code, true
+	}
+
+	var exitErr *exec.ExitError
+
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), true
+	}
+
+	return exitErr.ExitCode(), true
+}
+
+// ReachedRemote reports whether ssh connected and authenticated, so any failure came from the remote command.
+func ReachedRemote(err error) bool {
+	if err == nil {
+		return true
+	}
+
+	code, ok := ExitCode(err)
+
+	return ok && code != sshErrorStatus
+}
@@ -0,0 +1,65 @@
+package ssh
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func runShell(t *testing.T, script string) error {
+	t.Helper()
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	return runCommand(exec.Command("sh", "-c", script))
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		script   string
+		expected int
+	}{
+		{"exit 3", 3},
+		{"exit 255", 255},
+		{"kill -TERM $$", 143},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			code, ok := ExitCode(runShell(t, tt.script))
+
+			if !ok || code != tt.expected {
+				t.Errorf("ExitCode() = %d, %t, expected %d", code, ok, tt.expected)
+			}
+		})
+	}
+
+	if _, ok := ExitCode(errors.New("not an exit")); ok {
+		t.Errorf("expected errors without a status to be ignored")
+	}
+}
+
+func TestReachedRemote(t *testing.T) {
+	tests := []struct {
+		script   string
+		expected bool
+	}{
+		{"exit 0", true},
+		{"exit 3", true},
+		{"exit 255", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			if actual := ReachedRemote(runShell(t, tt.script)); actual != tt.expected {
+				t.Errorf("ReachedRemote() = %t, expected %t", actual, tt.expected)
+			}
+		})
+	}
+
+	if ReachedRemote(errors.New("ssh not found")) {
+		t.Errorf("expected failing to start ssh to not reach the remote")
+	}
+}
@@ -0,0 +1,263 @@
+package ssh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/JFenstermacher/awssh/pkg/finder"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gdamore/tcell/v2"
+)
+
+func previewLine(name string, value string) string {
+	if value == "" {
+		value = "-"
+	}
+
+	return fmt.Sprintf("%-18s %s", name, value)
+}
+
+func getSSMPreview(instance inst.Instance) string {
+	if instance.SSMPingStatus == "" {
+		return ""
+	}
+
+	status := instance.SSMPingStatus
+
+	if instance.SSMAgentVersion != "" {
+		status = fmt.Sprintf("%s (agent %s)", status, instance.SSMAgentVersion)
+	}
+
+	if instance.SSMEnabled {
+		status = fmt.Sprintf("%s, reachable", status)
+	}
+
+	return status
+}
+
+func getSortedTagKeys(tags map[string]string) []string {
+	keys := []string{}
+
+	for key := range tags {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func instancePreview(instance inst.Instance) []string {
+	lines := []string{
+		previewLine("Instance ID", instance.InstanceId),
+		previewLine("Name", instance.Tags["Name"]),
+		previewLine("State", instance.State),
+		previewLine("Instance Type", instance.InstanceType),
+		previewLine("AMI", instance.ImageId),
+		previewLine("Key Pair", instance.KeyName),
+		previewLine("Profile", instance.Profile),
+		previewLine("Region", instance.Region),
+		previewLine("Availability Zone", instance.AvailabilityZone),
+		previewLine("VPC", instance.VpcId),
+		previewLine("Subnet", instance.SubnetId),
+		previewLine("Public IP", instance.PublicIpAddress),
+		previewLine("Private IP", instance.PrivateIpAddress),
+		previewLine("Public DNS", instance.PublicDnsName),
+		previewLine("Private DNS", instance.PrivateDnsName),
+		previewLine("SSM", getSSMPreview(instance)),
+	}
+
+	if len(instance.NetworkInterfaces) > 0 {
+		lines = append(lines, "", "Network Interfaces")
+
+		for _, eni := range instance.NetworkInterfaces {
+			addresses := append(append([]string{}, eni.PrivateIpAddresses...), eni.PublicIpAddresses...)
+			addresses = append(addresses, eni.Ipv6Addresses...)
+
+			lines = append(lines, fmt.Sprintf("  %s [%d] %s", eni.NetworkInterfaceId, eni.DeviceIndex, strings.Join(addresses, ", ")))
+		}
+	}
+
+	lines = append(lines, "", "Tags")
+
+	for _, key := range getSortedTagKeys(instance.Tags) {
+		lines = append(lines, fmt.Sprintf("  %s = %s", key, instance.Tags[key]))
+	}
+
+	return lines
+}
+
+func getFinderItems(instances *[]inst.Instance) ([]finder.Item, error) {
+	items := []finder.Item{}
+
+	labels, err := renderInstanceLabels(instances)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, label := range labels {
+		instance := (*instances)[idx]
+
+		// Tag values follow the label so matches within the label can be highlighted
+		search := []string{label}
+
+		for _, key := range getSortedTagKeys(instance.Tags) {
+			search = append(search, instance.Tags[key])
+		}
+
+		items = append(items, finder.Item{
+			Label:   label,
+			Search:  strings.Join(search, " "),
+			Preview: instancePreview(instance),
+			Dimmed:  instance.State != "running",
+		})
+	}
+
+	return items, nil
+}
+
+func findWithActions(instances *[]inst.Instance, actions []finder.Action) (inst.Instance, string, error) {
+	items, err := getFinderItems(instances)
+
+	if err != nil {
+		return inst.Instance{}, "", err
+	}
+
+	idx, action, err := finder.Find("Choose an instance", items, actions)
+
+	if err != nil {
+		return inst.Instance{}, "", err
+	}
+
+	return (*instances)[idx], action, nil
+}
+
+// findInstance lets the user connect to a running instance, copy an instance ID or start a stopped instance.
+func findInstance(instances *[]inst.Instance) (inst.Instance, error) {
+	actions := []finder.Action{
+		{
+			Key:  tcell.KeyEnter,
+			Name: "connect",
+			Run: func(idx int) (bool, string) {
+				instance := (*instances)[idx]
+
+				if instance.State != "running" {
+					return false, fmt.Sprintf("%s is %s, press Ctrl-S to start it", instance.InstanceId, instance.State)
+				}
+
+				return true, ""
+			},
+		},
+		{
+			Key:  tcell.KeyCtrlY,
+			Name: "copy id",
+			Run: func(idx int) (bool, string) {
+				id := (*instances)[idx].InstanceId
+
+				if err := utils.CopyToClipboard(id); err != nil {
+					return false, err.Error()
+				}
+
+				return false, fmt.Sprintf("Copied %s", id)
+			},
+		},
+		{
+			Key:  tcell.KeyCtrlS,
+			Name: "start",
+			Run: func(idx int) (bool, string) {
+				instance := (*instances)[idx]
+
+				if instance.State != ec2.InstanceStateNameStopped {
+					return false, fmt.Sprintf("%s is %s, only stopped instances can be started", instance.InstanceId, instance.State)
+				}
+
+				return true, ""
+			},
+		},
+	}
+
+	instance, action, err := findWithActions(instances, actions)
+
+	if err != nil || action != "start" {
+		return instance, err
+	}
+
+	if err := Power(&instance, inst.PowerStart); err != nil {
+		return instance, err
+	}
+
+	return describeInstance(instance)
+}
+
+func findAnyInstance(instances *[]inst.Instance) (inst.Instance, error) {
+	actions := []finder.Action{
+		{
+			Key:  tcell.KeyEnter,
+			Name: "select",
+			Run: func(idx int) (bool, string) {
+				return true, ""
+			},
+		},
+	}
+
+	instance, _, err := findWithActions(instances, actions)
+
+	return instance, err
+}
+
+func findInstances(instances *[]inst.Instance) ([]inst.Instance, error) {
+	items, err := getFinderItems(instances)
+
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := finder.FindMany("Choose instances", items)
+
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []inst.Instance{}
+
+	for _, idx := range indexes {
+		selected = append(selected, (*instances)[idx])
+	}
+
+	return selected, nil
+}
+
+// describeInstance reloads an instance, as addresses are assigned when it starts.
+func describeInstance(instance inst.Instance) (inst.Instance, error) {
+	scope, err := inst.NewScope(instance.Profile, instance.Region)
+
+	if err != nil {
+		return instance, err
+	}
+
+	filters := []*ec2.Filter{
+		{Name: aws.String("instance-id"), Values: aws.StringSlice([]string{instance.InstanceId})},
+	}
+
+	instanceChan, errc := inst.GetInstancesChannel(inst.DefaultClients.EC2(scope), filters)
+
+	for i := range instanceChan {
+		described := inst.FromEC2(i.Instance)
+
+		described.AccountId = i.AccountId
+		described.Profile = instance.Profile
+		described.Region = instance.Region
+		described.SSMEnabled = instance.SSMEnabled
+		described.SSMPingStatus = instance.SSMPingStatus
+		described.SSMAgentVersion = instance.SSMAgentVersion
+
+		instance = described
+	}
+
+	return instance, <-errc
+}
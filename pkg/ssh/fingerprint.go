@@ -0,0 +1,108 @@
+package ssh
+
+import (
+	"crypto"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// colonHex renders a digest the way EC2 reports hex fingerprints.
+func colonHex(digest []byte) string {
+	encoded := hex.EncodeToString(digest)
+	pairs := []string{}
+
+	for idx := 0; idx < len(encoded); idx += 2 {
+		pairs = append(pairs, encoded[idx:idx+2])
+	}
+
+	return strings.Join(pairs, ":")
+}
+
+// normalizeFingerprint drops the SHA256: prefix and base64 padding, which differ between EC2 and ssh-keygen.
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimRight(strings.TrimPrefix(strings.TrimSpace(fingerprint), "SHA256:"), "=")
+
+	// Hex fingerprints are case insensitive, base64 ones aren't
+	if strings.Contains(fingerprint, ":") {
+		return strings.ToLower(fingerprint)
+	}
+
+	return fingerprint
+}
+
+// getPublicKeyFingerprints computes the MD5 of the public key's DER, as EC2 reports for imported RSA keys,
+// and the SHA-256 of the public key as reported for ed25519 keys.
+func getPublicKeyFingerprints(public crypto.PublicKey) []string {
+	fingerprints := []string{}
+
+	if der, err := x509.MarshalPKIXPublicKey(public); err == nil {
+		digest := md5.Sum(der)
+		fingerprints = append(fingerprints, colonHex(digest[:]))
+	}
+
+	if sshKey, err := gossh.NewPublicKey(public); err == nil {
+		fingerprints = append(fingerprints, normalizeFingerprint(gossh.FingerprintSHA256(sshKey)))
+	}
+
+	return fingerprints
+}
+
+// GetKeyFingerprints computes every fingerprint EC2 may report for a private key: those of its public key
+// and the SHA-1 of the private key's PKCS#8 DER for RSA keys created by EC2.
+// Files that aren't unencrypted private keys have none.
+func GetKeyFingerprints(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := gossh.ParseRawPrivateKey(data)
+
+	if err != nil {
+		return []string{}, nil
+	}
+
+	signer, ok := key.(crypto.Signer)
+
+	if !ok {
+		return []string{}, nil
+	}
+
+	fingerprints := getPublicKeyFingerprints(signer.Public())
+
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		if der, err := x509.MarshalPKCS8PrivateKey(rsaKey); err == nil {
+			digest := sha1.Sum(der)
+			fingerprints = append(fingerprints, colonHex(digest[:]))
+		}
+	}
+
+	return fingerprints, nil
+}
+
+func containsFingerprint(fingerprints []string, fingerprint string) bool {
+	expected := normalizeFingerprint(fingerprint)
+
+	for _, actual := range fingerprints {
+		if actual == expected {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchesFingerprint reports whether the key at path has the given EC2 fingerprint.
+func MatchesFingerprint(path string, fingerprint string) bool {
+	fingerprints, err := GetKeyFingerprints(path)
+
+	return err == nil && containsFingerprint(fingerprints, fingerprint)
+}
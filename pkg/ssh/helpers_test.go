@@ -0,0 +1,55 @@
+package ssh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// setupConfig isolates viper and the home directory for a single test.
+func setupConfig(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+
+	viper.Reset()
+	viper.Set("HOME", home)
+	config.SetDefaults(false)
+
+	// Tests never reach the developer's own agent
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	t.Cleanup(viper.Reset)
+
+	return home
+}
+
+func newFlags(t *testing.T, args ...string) *pflag.FlagSet {
+	t.Helper()
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+
+	flags.StringP("identityFile", "i", "", "")
+	flags.StringP("loginName", "l", "", "")
+	flags.IntP("port", "p", 22, "")
+	flags.StringSliceP("option", "o", []string{}, "")
+	flags.StringArray("tag", []string{}, "")
+	flags.StringArray("filter", []string{}, "")
+	flags.BoolP("dryRun", "d", false, "")
+	flags.Bool("eic", false, "")
+	flags.Bool("ssm", false, "")
+	flags.Bool("pub", false, "")
+	flags.Bool("priv", false, "")
+	flags.BoolP("recursive", "r", false, "")
+	flags.Bool("rsync", false, "")
+	flags.Duration("timeout", 10*time.Minute, "")
+
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	return flags
+}
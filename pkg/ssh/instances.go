@@ -3,88 +3,182 @@ package ssh
 import (
 	"bytes"
 	"errors"
-	"log"
+	"fmt"
 	"text/template"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/JFenstermacher/awssh/pkg/config"
 	inst "github.com/JFenstermacher/awssh/pkg/instances"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/fatih/color"
 	"github.com/spf13/pflag"
 )
 
 type GetInstancesInput struct {
-	Session *session.Session
-	SSM     bool
-	Filter  func(instance inst.Instance) bool
+	Clients   inst.ClientProvider
+	Scopes    []*inst.Scope
+	SSM       bool
+	Filters   []*ec2.Filter
+	Filter    func(instance inst.Instance) bool
+	Inventory *Inventory
+	Refresh   bool
 }
 
-func GetInstances(input *GetInstancesInput) []inst.Instance {
-	associated := map[string]interface{}{}
+func listScopeInstances(input *GetInstancesInput, scope *inst.Scope) ([]inst.Instance, error) {
+	statuses := map[string]inst.SSMStatus{}
 	instances := []inst.Instance{}
 
-	if input.Session == nil {
-		log.Fatal("Valid AWS session must be passed")
+	clients := input.Clients
+
+	if clients == nil {
+		clients = inst.DefaultClients
 	}
 
-	instanceChan := inst.GetInstancesChannel(input.Session)
+	instanceChan, errc := inst.GetInstancesChannel(clients.EC2(scope), input.Filters)
+
+	var statusErr error
 
 	if input.SSM {
-		infoChan := inst.GetInstanceInfoChannel(input.Session)
+		statuses, statusErr = inst.GetSSMStatuses(clients.SSM(scope))
+	}
+
+	for i := range instanceChan {
+		instance := inst.FromEC2(i.Instance)
+
+		status := statuses[instance.InstanceId]
+
+		instance.AccountId = i.AccountId
+		instance.Profile = scope.Profile
+		instance.Region = scope.Region
+		instance.SSMEnabled = status.Reachable()
+		instance.SSMPingStatus = status.PingStatus
+		instance.SSMAgentVersion = status.AgentVersion
+
+		instances = append(instances, instance)
+	}
+
+	if err := <-errc; err != nil {
+		return nil, err
+	}
 
-		for info := range infoChan {
-			if *info.AssociationStatus == "Success" {
-				associated[*info.InstanceId] = nil
+	if statusErr != nil {
+		return nil, statusErr
+	}
+
+	return instances, nil
+}
+
+// getScopeInstances serves listings from the inventory when possible, refreshing stale entries in the background.
+// Only successful listings are stored, so a failure never replaces what was known about the scope.
+func getScopeInstances(input *GetInstancesInput, scope *inst.Scope) ([]inst.Instance, error) {
+	list := func() ([]inst.Instance, error) {
+		return listScopeInstances(input, scope)
+	}
+
+	if input.Inventory == nil {
+		return list()
+	}
+
+	signature := getFiltersSignature(input.Filters, input.SSM)
+
+	if !input.Refresh {
+		if instances, stale, found := input.Inventory.Get(scope, signature); found {
+			if stale {
+				input.Inventory.RefreshInBackground(scope, signature, list)
 			}
+
+			return instances, nil
 		}
 	}
 
-	for i := range instanceChan {
-		_, found := associated[*i.InstanceId]
-
-		instance := inst.Instance{
-			ImageId:          *i.ImageId,
-			InstanceId:       *i.InstanceId,
-			InstanceType:     *i.InstanceType,
-			KeyName:          *i.KeyName,
-			PrivateIpAddress: *i.PrivateIpAddress,
-			PublicIpAddress:  *i.PublicIpAddress,
-			SubnetId:         *i.SubnetId,
-			VpcId:            *i.VpcId,
-			State:            *i.State.Name,
-			SSMEnabled:       found,
-			Tags:             inst.RemapTags(i.Tags),
+	instances, err := list()
+
+	if err != nil {
+		return nil, err
+	}
+
+	input.Inventory.Put(scope, signature, instances)
+
+	return instances, nil
+}
+
+// GetInstances lists instances from every scope concurrently, keeping the order of the scopes.
+func GetInstances(input *GetInstancesInput) ([]inst.Instance, error) {
+	if len(input.Scopes) == 0 {
+		return nil, errors.New("Valid AWS session must be passed")
+	}
+
+	results := make([][]inst.Instance, len(input.Scopes))
+	errs := make([]error, len(input.Scopes))
+
+	inst.ForEachScope(input.Scopes, func(idx int, scope *inst.Scope) {
+		results[idx], errs[idx] = getScopeInstances(input, scope)
+	})
+
+	var failed error
+
+	for idx, err := range errs {
+		if err != nil {
+			failed = fmt.Errorf("listing instances in %s: %w", input.Scopes[idx], err)
+
+			fmt.Fprintf(stderrWriter, "Warning: %v\n", failed)
 		}
+	}
+
+	instances := []inst.Instance{}
 
-		if input.Filter != nil && input.Filter(instance) {
-			instances = append(instances, instance)
+	for _, result := range results {
+		for _, instance := range result {
+			if input.Filter == nil || input.Filter(instance) {
+				instances = append(instances, instance)
+			}
 		}
 	}
 
 	if len(instances) == 0 {
-		log.Fatal("No instances found")
+		// Without anything to pick from, a failed scope is more likely the cause than an empty account
+		if failed != nil {
+			return nil, failed
+		}
+
+		return nil, ErrNoInstances
 	}
 
-	return instances
+	return instances, nil
 }
 
-func getInstanceLabels(instances *[]inst.Instance, templateString string) ([]string, map[string]inst.Instance) {
+func renderLabels(instances *[]inst.Instance, templateString string) ([]string, error) {
 	it, err := template.New("instance").Parse(templateString)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	labels, mapping := []string{}, map[string]inst.Instance{}
+	labels := []string{}
 	for _, instance := range *instances {
 		var label bytes.Buffer
 
 		if err := it.Execute(&label, instance); err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
-		key := label.String()
+		labels = append(labels, label.String())
+	}
+
+	return labels, nil
+}
+
+func getInstanceLabels(instances *[]inst.Instance) ([]string, map[string]inst.Instance, error) {
+	labels, mapping := []string{}, map[string]inst.Instance{}
+
+	rendered, err := renderInstanceLabels(instances)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for idx, key := range rendered {
+		instance := (*instances)[idx]
 
 		if instance.State != "running" {
 			key = color.RedString(key)
@@ -94,19 +188,40 @@ func getInstanceLabels(instances *[]inst.Instance, templateString string) ([]str
 		mapping[key] = instance
 	}
 
-	return labels, mapping
+	return labels, mapping, nil
 }
 
-func SelectInstance(instances *[]inst.Instance) inst.Instance {
-	templateString := config.GetTemplateString()
+// renderInstanceLabels renders labels with the configured template string.
+func renderInstanceLabels(instances *[]inst.Instance) ([]string, error) {
+	templateString, err := config.GetTemplateString()
 
-	if templateString == "" {
-		log.Fatal("Template String is not defined. Please reinitialize configuration.")
+	if err != nil {
+		return nil, err
 	}
 
+	labels, err := renderLabels(instances, templateString)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, instance := range *instances {
+		if instance.Stale {
+			labels[idx] = fmt.Sprintf("%s (stale)", labels[idx])
+		}
+	}
+
+	return labels, nil
+}
+
+func selectInstance(instances *[]inst.Instance, requireRunning bool) (inst.Instance, error) {
 	choice := ""
 
-	labels, mapping := getInstanceLabels(instances, templateString)
+	labels, mapping, err := getInstanceLabels(instances)
+
+	if err != nil {
+		return inst.Instance{}, err
+	}
 
 	prompt := &survey.Select{
 		Message: "Choose an instance",
@@ -118,7 +233,7 @@ func SelectInstance(instances *[]inst.Instance) inst.Instance {
 
 		instance := mapping[key.Value]
 
-		if instance.State != "running" {
+		if requireRunning && instance.State != "running" {
 			return errors.New("Please choose a running instance")
 		}
 
@@ -126,41 +241,224 @@ func SelectInstance(instances *[]inst.Instance) inst.Instance {
 	}
 
 	if err := survey.AskOne(prompt, &choice, survey.WithValidator(validator)); err != nil {
-		log.Fatal(err)
+		return inst.Instance{}, err
 	}
 
-	return mapping[choice]
+	return mapping[choice], nil
 }
 
-func PromptInstance(flags *pflag.FlagSet) *inst.Instance {
-	profile, _ := flags.GetString("profile")
-	region, _ := flags.GetString("region")
+func selectInstances(instances *[]inst.Instance) ([]inst.Instance, error) {
+	choices := []string{}
 
-	session := inst.GetSession(profile, region)
+	labels, mapping, err := getInstanceLabels(instances)
 
-	ssm := config.GetSSMEnabled()
+	if err != nil {
+		return nil, err
+	}
 
-	instances := GetInstances(&GetInstancesInput{
-		Session: session,
-		SSM:     ssm,
-		Filter: func(instance inst.Instance) bool {
+	prompt := &survey.MultiSelect{
+		Message: "Choose instances",
+		Options: labels,
+	}
+
+	if err := survey.AskOne(prompt, &choices, survey.WithValidator(survey.MinItems(1))); err != nil {
+		return nil, err
+	}
+
+	selected := []inst.Instance{}
+
+	for _, choice := range choices {
+		selected = append(selected, mapping[choice])
+	}
+
+	return selected, nil
+}
+
+func SelectInstance(instances *[]inst.Instance) (inst.Instance, error) {
+	if config.GetPicker() == "fuzzy" {
+		return findInstance(instances)
+	}
+
+	return selectInstance(instances, true)
+}
 
-			ssm, _ := flags.GetBool("ssm")
-			pub, _ := flags.GetBool("pub")
+// SelectInstances prompts for one or more instances.
+func SelectInstances(instances *[]inst.Instance) ([]inst.Instance, error) {
+	if config.GetPicker() == "fuzzy" {
+		return findInstances(instances)
+	}
+
+	return selectInstances(instances)
+}
+
+// SelectAnyInstance behaves like SelectInstance but allows choosing instances in any state.
+func SelectAnyInstance(instances *[]inst.Instance) (inst.Instance, error) {
+	if config.GetPicker() == "fuzzy" {
+		return findAnyInstance(instances)
+	}
+
+	return selectInstance(instances, false)
+}
+
+// listInstances lists the instances matching the selector and the connection flags.
+func listInstances(flags *pflag.FlagSet, selector *Selector) ([]inst.Instance, error) {
+	scopes, err := GetScopes(flags)
+
+	if err != nil {
+		return nil, err
+	}
 
-			if ssm {
-				return instance.SSMEnabled
+	input := newListInput(flags, selector, scopes)
+	input.Refresh, _ = flags.GetBool("refresh")
+
+	// Only unnarrowed listings are cached, selectors always query EC2 directly
+	if selector.Empty() {
+		input.Inventory = NewInventory(GetInventoryPath(), config.GetInventoryTTL())
+	}
+
+	return GetInstances(input)
+}
+
+// newListInput lists the instances of the scopes matching the selector and reachable the way the flags connect.
+func newListInput(flags *pflag.FlagSet, selector *Selector, scopes []*inst.Scope) *GetInstancesInput {
+	return &GetInstancesInput{
+		Scopes:  scopes,
+		SSM:     config.GetSSMEnabled() || selector.NeedsSSM() || ViaSSM(flags),
+		Filters: selector.EC2Filters(),
+		Filter: func(instance inst.Instance) bool {
+			if !selector.Match(instance) {
+				return false
 			}
 
-			if pub {
-				return instance.PublicIpAddress != ""
+			if ViaSSM(flags) {
+				return ssmOnline(instance)
+			}
+
+			// --ssm, --pub and --priv only list instances reachable that way
+			if conn, _ := getForcedConnection(flags); conn != "" {
+				address, _, _ := resolveAddress(conn, &instance)
+
+				return address != ""
 			}
 
 			return true
 		},
+	}
+}
+
+func PromptInstance(flags *pflag.FlagSet, query string) (*inst.Instance, error) {
+	selector, err := NewSelector(flags, query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := listInstances(flags, selector)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A selector narrowing down to a single instance skips the prompt entirely
+	if !selector.Empty() && len(instances) == 1 {
+		instance := instances[0]
+
+		if instance.State != "running" {
+			return nil, fmt.Errorf("%w: %s is %s", ErrNotRunning, instance.InstanceId, instance.State)
+		}
+
+		return &instance, nil
+	}
+
+	instance, err := SelectInstance(&instances)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &instance, nil
+}
+
+func getRunningInstances(instances []inst.Instance) []inst.Instance {
+	running := []inst.Instance{}
+
+	for _, instance := range instances {
+		if instance.State == "running" {
+			running = append(running, instance)
+		}
+	}
+
+	return running
+}
+
+// PromptInstances returns every running instance matching a selector, otherwise several are chosen from a prompt.
+func PromptInstances(flags *pflag.FlagSet, query string) ([]inst.Instance, error) {
+	selector, err := NewSelector(flags, query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := listInstances(flags, selector)
+
+	if err != nil {
+		return nil, err
+	}
+
+	running := getRunningInstances(instances)
+
+	if len(running) == 0 {
+		return nil, fmt.Errorf("%w: none of the %d matching instances are running", ErrNotRunning, len(instances))
+	}
+
+	if !selector.Empty() {
+		return running, nil
+	}
+
+	return SelectInstances(&running)
+}
+
+func PromptAnyInstance(flags *pflag.FlagSet) (*inst.Instance, error) {
+	scopes, err := GetScopes(flags)
+
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := GetInstances(&GetInstancesInput{
+		Scopes: scopes,
 	})
 
-	instance := SelectInstance(&instances)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := SelectAnyInstance(&instances)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &instance, nil
+}
+
+// GetScopes combines --profile/--profiles and --region/--regions/--all-regions into scopes.
+func GetScopes(flags *pflag.FlagSet) ([]*inst.Scope, error) {
+	profile, _ := flags.GetString("profile")
+	profiles, _ := flags.GetStringSlice("profiles")
+
+	region, _ := flags.GetString("region")
+	regions, _ := flags.GetStringSlice("regions")
+
+	allRegions, _ := flags.GetBool("all-regions")
+
+	if profile != "" {
+		profiles = append([]string{profile}, profiles...)
+	}
+
+	if region != "" {
+		regions = append([]string{region}, regions...)
+	}
 
-	return &instance
+	return inst.GetScopes(profiles, regions, allRegions)
 }
@@ -0,0 +1,215 @@
+package ssh
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/instances/fake"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func newEC2Instance(id string, name string, state string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:       aws.String(id),
+		PrivateIpAddress: aws.String("10.0.0.1"),
+		State:            &ec2.InstanceState{Name: aws.String(state)},
+		Tags:             []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(name)}},
+	}
+}
+
+func getInstanceIds(instances []inst.Instance) []string {
+	ids := []string{}
+
+	for _, instance := range instances {
+		ids = append(ids, instance.InstanceId)
+	}
+
+	return ids
+}
+
+func TestGetInstances(t *testing.T) {
+	east := &fake.EC2{
+		PageSize: 1,
+		Instances: []*ec2.Instance{
+			newEC2Instance("i-east1", "web-1", "running"),
+			newEC2Instance("i-east2", "web-2", "stopped"),
+		},
+	}
+
+	west := &fake.EC2{
+		OwnerId: "111122223333",
+		Instances: []*ec2.Instance{
+			newEC2Instance("i-west1", "db-1", "running"),
+		},
+	}
+
+	provider := &fake.Provider{
+		EC2Clients: map[string]*fake.EC2{"us-east-1": east, "us-west-2": west},
+		SSMClients: map[string]*fake.SSM{
+			"us-west-2": {
+				Information: []*ssm.InstanceInformation{
+					{
+						InstanceId:   aws.String("i-west1"),
+						PingStatus:   aws.String(ssm.PingStatusOnline),
+						AgentVersion: aws.String("3.1.501.0"),
+					},
+				},
+			},
+		},
+	}
+
+	filters := []*ec2.Filter{
+		{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"running", "stopped"})},
+	}
+
+	instances, err := GetInstances(&GetInstancesInput{
+		Clients: provider,
+		Scopes: []*inst.Scope{
+			{Profile: "dev", Region: "us-east-1"},
+			{Profile: "dev", Region: "us-west-2"},
+		},
+		SSM:     true,
+		Filters: filters,
+		Filter: func(instance inst.Instance) bool {
+			return instance.InstanceId != "i-east2"
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := getInstanceIds(instances); !reflect.DeepEqual(ids, []string{"i-east1", "i-west1"}) {
+		t.Fatalf("unexpected instances %v", ids)
+	}
+
+	if instances[1].Region != "us-west-2" || instances[1].Profile != "dev" || instances[1].AccountId != "111122223333" {
+		t.Errorf("expected scope and account to be recorded on instance, got %s/%s/%s", instances[1].Profile, instances[1].Region, instances[1].AccountId)
+	}
+
+	if instances[0].SSMEnabled || !instances[1].SSMEnabled {
+		t.Errorf("expected only i-west1 to be SSM enabled")
+	}
+
+	if len(east.Inputs) != 1 || !reflect.DeepEqual(east.Inputs[0].Filters, filters) {
+		t.Errorf("expected filters to be sent to DescribeInstances, got %v", east.Inputs)
+	}
+}
+
+func TestGetInstancesNoneFound(t *testing.T) {
+	provider := &fake.Provider{
+		EC2Clients: map[string]*fake.EC2{
+			"us-east-1": {Instances: []*ec2.Instance{newEC2Instance("i-east1", "web-1", "stopped")}},
+		},
+	}
+
+	_, err := GetInstances(&GetInstancesInput{
+		Clients: provider,
+		Scopes:  []*inst.Scope{{Region: "us-east-1"}, {Region: "us-west-2"}},
+		Filter: func(instance inst.Instance) bool {
+			return instance.State == "running"
+		},
+	})
+
+	if !errors.Is(err, ErrNoInstances) {
+		t.Errorf("expected ErrNoInstances, got %v", err)
+	}
+}
+
+func TestGetInstancesScopeErrors(t *testing.T) {
+	denied := errors.New("UnauthorizedOperation")
+
+	provider := &fake.Provider{
+		EC2Clients: map[string]*fake.EC2{
+			"us-east-1": {Instances: []*ec2.Instance{newEC2Instance("i-east1", "web-1", "running")}},
+			"us-west-2": {Err: denied},
+		},
+		SSMClients: map[string]*fake.SSM{
+			"eu-west-1": {Err: denied},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		scopes   []*inst.Scope
+		expected []string
+		warning  string
+	}{
+		{
+			name:     "one scope fails",
+			scopes:   []*inst.Scope{{Profile: "dev", Region: "us-east-1"}, {Profile: "dev", Region: "us-west-2"}},
+			expected: []string{"i-east1"},
+			warning:  "Warning: listing instances in dev/us-west-2: UnauthorizedOperation\n",
+		},
+		{
+			name:    "every scope fails",
+			scopes:  []*inst.Scope{{Region: "us-west-2"}},
+			warning: "Warning: listing instances in default/us-west-2: UnauthorizedOperation\n",
+		},
+		{
+			name:    "ssm fails",
+			scopes:  []*inst.Scope{{Region: "eu-west-1"}},
+			warning: "Warning: listing instances in default/eu-west-1: UnauthorizedOperation\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := captureOutput(t)
+
+			instances, err := GetInstances(&GetInstancesInput{Clients: provider, Scopes: test.scopes, SSM: true})
+
+			if out.String() != test.warning {
+				t.Errorf("expected warning %q, got %q", test.warning, out.String())
+			}
+
+			if test.expected == nil {
+				if !errors.Is(err, denied) {
+					t.Errorf("expected the listing error, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ids := getInstanceIds(instances); !reflect.DeepEqual(ids, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, ids)
+			}
+		})
+	}
+}
+
+func TestRenderLabelsStale(t *testing.T) {
+	setupConfig(t)
+
+	instances := []inst.Instance{{InstanceId: "i-1"}, {InstanceId: "i-2", Stale: true}}
+
+	labels, err := renderLabels(&instances, "{{ .InstanceId }}")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"i-1", "i-2"}; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("renderLabels() = %v, expected %v", labels, expected)
+	}
+
+	// Only the picker flags stale instances, generated aliases stay stable
+	labels, err = renderInstanceLabels(&instances)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.HasSuffix(labels[0], "(stale)") || !strings.HasSuffix(labels[1], "] (stale)") {
+		t.Errorf("renderInstanceLabels() = %v", labels)
+	}
+}
@@ -0,0 +1,137 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"gopkg.in/yaml.v2"
+)
+
+type InventoryEntry struct {
+	FetchedAt time.Time
+	Filters   string
+	Instances []inst.Instance
+}
+
+// Inventory caches instance listings on disk, keyed by profile and region.
+type Inventory struct {
+	path    string
+	ttl     time.Duration
+	mu      sync.Mutex
+	Entries map[string]*InventoryEntry
+}
+
+var refreshes sync.WaitGroup
+
+func GetInventoryPath() string {
+	cachepath := GetCachePath()
+
+	return filepath.Join(cachepath.Dir, "inventory.yaml")
+}
+
+func NewInventory(path string, ttl time.Duration) *Inventory {
+	inventory := &Inventory{
+		path:    path,
+		ttl:     ttl,
+		Entries: map[string]*InventoryEntry{},
+	}
+
+	if !readCacheFile(path, &inventory.Entries) || inventory.Entries == nil {
+		inventory.Entries = map[string]*InventoryEntry{}
+	}
+
+	return inventory
+}
+
+func getInventoryKey(scope *inst.Scope) string {
+	return scope.String()
+}
+
+// getFiltersSignature identifies the listing, so changing default filters or SSM invalidates entries.
+func getFiltersSignature(filters []*ec2.Filter, ssm bool) string {
+	parts := []string{fmt.Sprintf("ssm=%t", ssm)}
+
+	for _, filter := range filters {
+		values := aws.StringValueSlice(filter.Values)
+		sort.Strings(values)
+
+		parts = append(parts, fmt.Sprintf("%s=%s", aws.StringValue(filter.Name), strings.Join(values, ",")))
+	}
+
+	sort.Strings(parts)
+
+	return strings.Join(parts, ";")
+}
+
+func (inv *Inventory) Get(scope *inst.Scope, signature string) ([]inst.Instance, bool, bool) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	entry, found := inv.Entries[getInventoryKey(scope)]
+
+	if !found || entry.Filters != signature {
+		return nil, false, false
+	}
+
+	stale := time.Since(entry.FetchedAt) > inv.ttl
+
+	instances := []inst.Instance{}
+
+	for _, instance := range entry.Instances {
+		instance.Stale = stale
+		instances = append(instances, instance)
+	}
+
+	return instances, stale, true
+}
+
+func (inv *Inventory) Put(scope *inst.Scope, signature string, instances []inst.Instance) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	inv.Entries[getInventoryKey(scope)] = &InventoryEntry{
+		FetchedAt: time.Now(),
+		Filters:   signature,
+		Instances: instances,
+	}
+
+	data, err := yaml.Marshal(inv.Entries)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(inv.path), 0755); err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(inv.path, data, 0644)
+}
+
+// RefreshInBackground lists the scope again and stores it, without blocking the caller.
+// A failed listing keeps the current entry, there is nobody left to report it to.
+func (inv *Inventory) RefreshInBackground(scope *inst.Scope, signature string, list func() ([]inst.Instance, error)) {
+	refreshes.Add(1)
+
+	go func() {
+		defer refreshes.Done()
+
+		if instances, err := list(); err == nil {
+			inv.Put(scope, signature, instances)
+		}
+	}()
+}
+
+// WaitForRefresh blocks until background inventory refreshes are written.
+func WaitForRefresh() {
+	refreshes.Wait()
+}
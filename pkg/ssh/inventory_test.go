@@ -0,0 +1,152 @@
+package ssh
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/instances/fake"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestGetScopeInstancesKeepsEntryOnFailure(t *testing.T) {
+	scope := &inst.Scope{Profile: "dev", Region: "us-east-1"}
+	signature := getFiltersSignature(nil, false)
+
+	provider := &fake.Provider{
+		EC2Clients: map[string]*fake.EC2{"us-east-1": {Err: errors.New("RequestLimitExceeded")}},
+	}
+
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		refresh bool
+	}{
+		{name: "forced refresh", ttl: time.Hour, refresh: true},
+		{name: "background refresh", ttl: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "inventory.yaml")
+
+			inventory := NewInventory(path, test.ttl)
+			inventory.Put(scope, signature, []inst.Instance{{InstanceId: "i-known"}})
+
+			fetchedAt := inventory.Entries[getInventoryKey(scope)].FetchedAt
+
+			getScopeInstances(&GetInstancesInput{Clients: provider, Inventory: inventory, Refresh: test.refresh}, scope)
+
+			WaitForRefresh()
+
+			entry := NewInventory(path, test.ttl).Entries[getInventoryKey(scope)]
+
+			if ids := getInstanceIds(entry.Instances); !reflect.DeepEqual(ids, []string{"i-known"}) || !entry.FetchedAt.Equal(fetchedAt) {
+				t.Errorf("expected the failed listing to keep the entry, got %v fetched at %s", ids, entry.FetchedAt)
+			}
+		})
+	}
+}
+
+func TestInventoryGet(t *testing.T) {
+	scope := &inst.Scope{Profile: "dev", Region: "us-east-1"}
+	signature := getFiltersSignature(nil, true)
+
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		scope     *inst.Scope
+		signature string
+		found     bool
+		stale     bool
+	}{
+		{name: "fresh", ttl: time.Hour, scope: scope, signature: signature, found: true},
+		{name: "expired", ttl: 0, scope: scope, signature: signature, found: true, stale: true},
+		{name: "signature mismatch", ttl: time.Hour, scope: scope, signature: getFiltersSignature(nil, false)},
+		{name: "unknown scope", ttl: time.Hour, scope: &inst.Scope{Profile: "dev", Region: "us-west-2"}, signature: signature},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupConfig(t)
+
+			NewInventory(GetInventoryPath(), test.ttl).Put(scope, signature, []inst.Instance{{InstanceId: "i-1"}})
+
+			instances, stale, found := NewInventory(GetInventoryPath(), test.ttl).Get(test.scope, test.signature)
+
+			if found != test.found || stale != test.stale {
+				t.Fatalf("expected found %t and stale %t, got %t and %t", test.found, test.stale, found, stale)
+			}
+
+			if !found {
+				return
+			}
+
+			if len(instances) != 1 || instances[0].InstanceId != "i-1" || instances[0].Stale != test.stale {
+				t.Errorf("expected i-1 marked stale %t, got %+v", test.stale, instances)
+			}
+		})
+	}
+}
+
+func TestGetScopeInstancesInventory(t *testing.T) {
+	scope := &inst.Scope{Profile: "dev", Region: "us-east-1"}
+
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		refresh bool
+		stale   bool
+		lists   int
+	}{
+		{name: "served from inventory", ttl: time.Hour, lists: 1},
+		{name: "refreshed in background", ttl: 0, stale: true, lists: 2},
+		{name: "forced refresh", ttl: time.Hour, refresh: true, lists: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupConfig(t)
+
+			client := &fake.EC2{Instances: []*ec2.Instance{newEC2Instance("i-east1", "web-1", "running")}}
+
+			input := &GetInstancesInput{
+				Clients:   &fake.Provider{EC2Clients: map[string]*fake.EC2{"us-east-1": client}},
+				Inventory: NewInventory(GetInventoryPath(), test.ttl),
+			}
+
+			if _, err := getScopeInstances(input, scope); err != nil {
+				t.Fatal(err)
+			}
+
+			fetchedAt := input.Inventory.Entries[getInventoryKey(scope)].FetchedAt
+
+			input.Refresh = test.refresh
+
+			instances, err := getScopeInstances(input, scope)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			WaitForRefresh()
+
+			if len(instances) != 1 || instances[0].Stale != test.stale {
+				t.Errorf("expected i-east1 marked stale %t, got %+v", test.stale, instances)
+			}
+
+			if len(client.Inputs) != test.lists {
+				t.Errorf("expected %d listings, got %d", test.lists, len(client.Inputs))
+			}
+
+			entry := NewInventory(GetInventoryPath(), test.ttl).Entries[getInventoryKey(scope)]
+
+			if refreshed := entry.FetchedAt.After(fetchedAt); refreshed != (test.lists > 1) {
+				t.Errorf("expected the stored entry to be refreshed %t, fetched at %s after %s", test.lists > 1, entry.FetchedAt, fetchedAt)
+			}
+		})
+	}
+}
@@ -2,29 +2,31 @@ package ssh
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/JFenstermacher/awssh/pkg/config"
 	inst "github.com/JFenstermacher/awssh/pkg/instances"
 )
 
-func GetKeys(dir string) []string {
+func GetKeys(dir string) ([]string, error) {
 	files := []string{}
 
 	file, err := os.Open(dir)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
+	defer file.Close()
+
 	infos, err := file.Readdir(0)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	for _, info := range infos {
@@ -34,51 +36,161 @@ func GetKeys(dir string) []string {
 	}
 
 	if len(files) == 0 {
-		log.Fatal(fmt.Sprintf("No keys available in %s", dir))
+		return nil, fmt.Errorf("%w in %s", ErrNoKeys, dir)
 	}
 
-	return files
+	return files, nil
 }
 
-func SelectKey(instance *inst.Instance, keys []string) string {
-	choice := ""
+// Key pairs are looked up once per process, instances commonly share them
+var (
+	keyPairsMu sync.Mutex
+	keyPairs   = map[string]*inst.KeyPair{}
+)
+
+func describeKeyPair(clients inst.ClientProvider, instance *inst.Instance) (*inst.KeyPair, error) {
+	keyPairsMu.Lock()
+	defer keyPairsMu.Unlock()
 
-	for _, key := range keys {
-		if strings.HasPrefix(key, instance.KeyName) {
-			choice = key
-			break
+	cacheKey := fmt.Sprintf("%s/%s/%s", instance.Profile, instance.Region, instance.KeyName)
+
+	if pair, found := keyPairs[cacheKey]; found {
+		return pair, nil
+	}
+
+	scope, err := inst.NewScope(instance.Profile, instance.Region)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := inst.DescribeKeyPair(clients.EC2(scope), instance.KeyName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyPairs[cacheKey] = pair
+
+	return pair, nil
+}
+
+// MatchKey finds the key in dir whose fingerprint matches the instance's key pair. When the key pair can't be described,
+// only a file named exactly after it is trusted, as prefixes are easily fooled.
+func MatchKey(clients inst.ClientProvider, instance *inst.Instance, dir string, keys []string) string {
+	if instance.KeyName == "" {
+		return ""
+	}
+
+	if pair, err := describeKeyPair(clients, instance); err == nil && pair != nil {
+		for _, key := range keys {
+			if MatchesFingerprint(filepath.Join(dir, key), pair.Fingerprint) {
+				return key
+			}
 		}
+
+		return ""
 	}
 
-	if choice == "" {
-		prompt := &survey.Select{
-			Message: "Choose instance private key",
-			Options: keys,
+	for _, key := range keys {
+		if key == instance.KeyName || strings.TrimSuffix(key, filepath.Ext(key)) == instance.KeyName {
+			return key
 		}
+	}
+
+	return ""
+}
+
+// MatchAgentIdentity finds the agent identity whose fingerprint matches the instance's key pair.
+func MatchAgentIdentity(clients inst.ClientProvider, instance *inst.Instance, identities []*AgentIdentity) *AgentIdentity {
+	if instance.KeyName == "" || len(identities) == 0 {
+		return nil
+	}
+
+	pair, err := describeKeyPair(clients, instance)
 
-		if err := survey.AskOne(prompt, &choice); err != nil {
-			log.Fatal(err)
+	if err != nil || pair == nil {
+		return nil
+	}
+
+	for _, identity := range identities {
+		if identity.Matches(pair.Fingerprint) {
+			return identity
 		}
 	}
 
-	return choice
+	return nil
+}
+
+// SelectKey prompts for one of the keys or agent identities, returning the chosen key or an empty key for an identity.
+func SelectKey(instance *inst.Instance, keys []string, identities []*AgentIdentity) (string, error) {
+	message := "Choose instance private key"
+
+	if instance.KeyName != "" {
+		message = fmt.Sprintf("No key matches key pair %s, choose instance private key", instance.KeyName)
+	}
+
+	options := append([]string{}, keys...)
+
+	for _, identity := range identities {
+		options = append(options, identity.Label())
+	}
+
+	prompt := &survey.Select{
+		Message: message,
+		Options: options,
+	}
+
+	choice := 0
+
+	if err := survey.AskOne(prompt, &choice); err != nil {
+		return "", err
+	}
+
+	if choice >= len(keys) {
+		return "", nil
+	}
+
+	return keys[choice], nil
 }
 
-func PromptKey(instance *inst.Instance, cache *KeyCache) string {
-	keysDir := config.GetKeysDirectory()
+// PromptKey returns the cached key of the instance, the key matching its key pair or prompts for one.
+// An empty key is returned when an agent identity is used, ssh then authenticates with the agent.
+func PromptKey(clients inst.ClientProvider, instance *inst.Instance, cache *KeyCache) (string, error) {
+	keysDir, err := config.GetKeysDirectory()
 
-	if keysDir == "" {
-		log.Fatal("Keys Directory is not defined. Reinitialize cli.")
+	if err != nil {
+		return "", err
 	}
 
-	path, found := cache.Check(instance.InstanceId)
+	path, found := cache.Check(instance)
 
 	if found {
-		return path
+		return path, nil
+	}
+
+	keys, keysErr := GetKeys(keysDir)
+
+	// An unreachable agent is treated as having no identities
+	identities, _ := GetAgentIdentities()
+
+	if keysErr != nil && len(identities) == 0 {
+		return "", keysErr
+	}
+
+	if key := MatchKey(clients, instance, keysDir, keys); key != "" {
+		return filepath.Join(keysDir, key), nil
 	}
 
-	keys := GetKeys(keysDir)
-	key := SelectKey(instance, keys)
+	if identity := MatchAgentIdentity(clients, instance, identities); identity != nil {
+		return "", nil
+	}
+
+	key, err := SelectKey(instance, keys, identities)
+
+	if err != nil || key == "" {
+		return "", err
+	}
 
-	return filepath.Join(keysDir, key)
+	return filepath.Join(keysDir, key), nil
 }
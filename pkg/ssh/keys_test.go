@@ -0,0 +1,141 @@
+package ssh
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/instances/fake"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/viper"
+)
+
+func writeKeys(t *testing.T, dir string, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPromptKeyMatchesExactKeyName(t *testing.T) {
+	setupConfig(t)
+
+	keysDir := t.TempDir()
+	viper.Set("KeysDirectory", keysDir)
+
+	// Without the key pair only the exact name is trusted, not deploy-old.pem
+	writeKeys(t, keysDir, "deploy-old.pem", "deploy.pem", "other.pem")
+
+	cache := NewKeyCache(GetCachePath().Path)
+	instance := &inst.Instance{InstanceId: "i-0123", KeyName: "deploy"}
+
+	actual, err := PromptKey(&fake.Provider{}, instance, cache)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual != filepath.Join(keysDir, "deploy.pem") {
+		t.Errorf("PromptKey() = %q, expected deploy.pem", actual)
+	}
+}
+
+func TestPromptKeyUsesCache(t *testing.T) {
+	setupConfig(t)
+
+	keysDir := t.TempDir()
+	viper.Set("KeysDirectory", keysDir)
+
+	writeKeys(t, keysDir, "deploy.pem", "cached.pem")
+
+	instance := &inst.Instance{InstanceId: "i-0123", KeyName: "deploy"}
+
+	if err := NewKeyCache(GetCachePath().Path).Save(instance, filepath.Join(keysDir, "cached.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewKeyCache(GetCachePath().Path)
+
+	actual, err := PromptKey(&fake.Provider{}, instance, cache)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual != filepath.Join(keysDir, "cached.pem") {
+		t.Errorf("PromptKey() = %q, expected cached.pem", actual)
+	}
+}
+
+func TestPromptKeyEmptyDirectory(t *testing.T) {
+	setupConfig(t)
+
+	viper.Set("KeysDirectory", t.TempDir())
+
+	cache := NewKeyCache(GetCachePath().Path)
+
+	if _, err := PromptKey(&fake.Provider{}, &inst.Instance{InstanceId: "i-0123"}, cache); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("expected ErrNoKeys, got %v", err)
+	}
+}
+
+func copyKey(t *testing.T, dir string, fixture string, name string) {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatchKeyByFingerprint(t *testing.T) {
+	tests := []struct {
+		name        string
+		fingerprint string
+		expected    string
+	}{
+		{"created rsa", "0d:f4:02:cf:c2:54:b6:b2:a8:22:40:18:2b:0c:96:d4:7f:cb:44:e4", "renamed.pem"},
+		{"imported rsa", "74:BD:ED:A1:80:B8:2C:DF:48:4B:A9:84:03:04:F4:03", "renamed.pem"},
+		{"ed25519", "gpZkuiGqtVP0lcQWq55uNu7qnatYVMVMUjxHHI+24bo=", "id_ed25519"},
+		{"no match", "00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfig(t)
+			t.Cleanup(func() { keyPairs = map[string]*inst.KeyPair{} })
+
+			keysDir := t.TempDir()
+
+			// prod.pem is named after the key pair but is a different key
+			writeKeys(t, keysDir, "prod.pem", "prod.pem.pub")
+			copyKey(t, keysDir, "rsa.pem", "renamed.pem")
+			copyKey(t, keysDir, "ed25519", "id_ed25519")
+
+			provider := &fake.Provider{
+				EC2Clients: map[string]*fake.EC2{
+					"us-east-1": {KeyPairs: []*ec2.KeyPairInfo{{KeyName: aws.String("prod"), KeyFingerprint: aws.String(tt.fingerprint)}}},
+				},
+			}
+
+			instance := &inst.Instance{InstanceId: "i-0123", KeyName: "prod", Region: "us-east-1"}
+
+			keys, _ := GetKeys(keysDir)
+
+			if actual := MatchKey(provider, instance, keysDir, keys); actual != tt.expected {
+				t.Errorf("MatchKey() = %q, expected %q", actual, tt.expected)
+			}
+		})
+	}
+}
@@ -1,15 +1,13 @@
 package ssh
 
 import (
-	"fmt"
-	"log"
 	"regexp"
 
 	"github.com/JFenstermacher/awssh/pkg/config"
 	"github.com/spf13/pflag"
 )
 
-func validateBooleanFlags(flags *pflag.FlagSet) {
+func validateBooleanFlags(flags *pflag.FlagSet) error {
 	ssm, _ := flags.GetBool("ssm")
 	pub, _ := flags.GetBool("pub")
 	priv, _ := flags.GetBool("priv")
@@ -17,7 +15,11 @@ func validateBooleanFlags(flags *pflag.FlagSet) {
 	opts := []bool{ssm, pub, priv}
 
 	if ssm && !config.GetSSMEnabled() {
-		log.Fatal("You must enable SSM via the config command")
+		return usageError("You must enable SSM via the config command")
+	}
+
+	if ssm && !config.IsSSMPossible() {
+		return usageError("session-manager-plugin must be installed to connect via SSM")
 	}
 
 	count := 0
@@ -28,25 +30,77 @@ func validateBooleanFlags(flags *pflag.FlagSet) {
 	}
 
 	if count > 1 {
-		log.Fatal("Please specify only one of the following flags: --ssm, --pub, --priv")
+		return usageError("Please specify only one of the following flags: --ssm, --pub, --priv")
 	}
+
+	return nil
 }
 
-func validateOptions(flags *pflag.FlagSet) {
+func validateOptions(flags *pflag.FlagSet) error {
 	options, _ := flags.GetStringSlice("option")
 
-	regex := regexp.MustCompile("^[^=]+=[^=]+$")
+	// Like ssh_config, values may follow '=' or whitespace and contain either themselves, e.g. LocalForward=5432 db:5432
+	regex := regexp.MustCompile(`^\w+(\s*=\s*|\s+)\S.*$`)
 
 	for _, opt := range options {
 		match := regex.Match([]byte(opt))
 
 		if !match {
-			log.Fatal(fmt.Sprintf("Options must be in form {key}={value}: [%s] failed.", opt))
+			return usageError("Options must be in form {key}={value} or {key} {value}: [%s] failed.", opt)
+		}
+	}
+
+	return nil
+}
+
+func validateEIC(flags *pflag.FlagSet) error {
+	eic, _ := flags.GetBool("eic")
+	key, _ := flags.GetString("identityFile")
+
+	if eic && key != "" {
+		return usageError("Please specify only one of the following flags: --eic, --identityFile")
+	}
+
+	return nil
+}
+
+func validateVia(flags *pflag.FlagSet) error {
+	via, err := flags.GetString("via")
+
+	if err != nil {
+		return nil
+	}
+
+	if via != "ssh" && via != "ssm" {
+		return usageError("--via must be one of: ssh, ssm")
+	}
+
+	if via == "ssh" {
+		return nil
+	}
+
+	for _, name := range []string{"eic", "identityFile", "pub", "priv"} {
+		if flags.Changed(name) {
+			return usageError("--%s only applies to ssh, not --via ssm", name)
 		}
 	}
+
+	return nil
 }
 
-func ValidateFlags(flags *pflag.FlagSet) {
-	validateBooleanFlags(flags)
-	validateOptions(flags)
+func ValidateFlags(flags *pflag.FlagSet) error {
+	validators := []func(flags *pflag.FlagSet) error{
+		validateBooleanFlags,
+		validateOptions,
+		validateEIC,
+		validateVia,
+	}
+
+	for _, validate := range validators {
+		if err := validate(flags); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,41 @@
+package ssh
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		valid bool
+	}{
+		{"no flags", nil, true},
+		{"single connection flag", []string{"--pub"}, true},
+		{"multiple connection flags", []string{"--pub", "--priv"}, false},
+		{"valid option", []string{"-o", "StrictHostKeyChecking=no"}, true},
+		{"invalid option", []string{"-o", "StrictHostKeyChecking"}, false},
+		{"option with spaces", []string{"-o", "LocalForward 5432 db.internal:5432"}, true},
+		{"option value with equals", []string{"-o", "ProxyCommand=ssh -o User=admin bastion -W %h:%p"}, true},
+		{"option without key", []string{"-o", "=value"}, false},
+		{"ssm disabled", []string{"--ssm"}, false},
+		{"eic with identity file", []string{"--eic", "-i", "key.pem"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfig(t)
+
+			err := ValidateFlags(newFlags(t, tt.args...))
+
+			if tt.valid && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+
+			if !tt.valid && !errors.Is(err, ErrUsage) {
+				t.Errorf("expected ErrUsage, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/briandowns/spinner"
+)
+
+func Power(instance *inst.Instance, action inst.PowerAction) error {
+	id := instance.InstanceId
+	scope, err := inst.NewScope(instance.Profile, instance.Region)
+
+	if err != nil {
+		return err
+	}
+
+	svc := inst.DefaultClients.EC2(scope)
+
+	if err := inst.ChangePower(svc, id, action); err != nil {
+		return err
+	}
+
+	state := action.TargetState()
+
+	if state == "" {
+		fmt.Fprintf(os.Stderr, "%s of %s requested\n", action, id)
+
+		return nil
+	}
+
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond, spinner.WithWriter(os.Stderr))
+	s.Suffix = fmt.Sprintf(" Waiting for %s to be %s", id, state)
+	s.Start()
+
+	err = inst.WaitForState(svc, id, state)
+
+	s.Stop()
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%s is %s\n", id, state)
+
+	return nil
+}
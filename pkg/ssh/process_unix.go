@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package ssh
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var stopSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGTERM,
+}
+
+// detach starts cmd in its own session, so it outlives the terminal awssh was started from.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+func stopProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+
+	if err != nil {
+		return err
+	}
+
+	return process.Signal(syscall.SIGTERM)
+}
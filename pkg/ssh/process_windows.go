@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package ssh
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+const detachedProcess = 0x00000008
+
+var stopSignals = []os.Signal{
+	os.Interrupt,
+}
+
+// detach starts cmd without a console, so it outlives the terminal awssh was started from.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// stopProcess kills the process along with the ssh or session-manager-plugin it runs, as Windows can't deliver signals to it
+// and killing only the process would leave its children holding the forwarded port.
+func stopProcess(pid int) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run()
+}
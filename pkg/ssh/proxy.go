@@ -0,0 +1,129 @@
+package ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/spf13/pflag"
+)
+
+func getSessionRegion(profile string, region string) (string, error) {
+	if region != "" {
+		return region, nil
+	}
+
+	session, err := inst.GetSession(profile, region)
+
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(session.Config.Region), nil
+}
+
+// ssmProxyCommand drives the AWS CLI when installed, otherwise awssh invokes session-manager-plugin itself.
+func ssmProxyCommand(profile string, region string) (string, error) {
+	region, err := getSessionRegion(profile, region)
+
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := exec.LookPath("aws"); err == nil {
+		components := []string{"aws", "ssm", "start-session", "--target", "%h", "--document-name", inst.SSHSessionDocument, "--parameters", "portNumber=%p"}
+
+		if profile != "" {
+			components = append(components, "--profile", profile)
+		}
+
+		components = append(components, "--region", region)
+
+		return utils.ShellJoin(components), nil
+	}
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		return "", err
+	}
+
+	components := []string{executable, "proxy"}
+
+	if profile != "" {
+		components = append(components, "--profile", profile)
+	}
+
+	components = append(components, "--region", region, "%h", "%p")
+
+	return utils.ShellJoin(components), nil
+}
+
+func GetProxyCommand(flags *pflag.FlagSet, instance *inst.Instance) ([]string, error) {
+	conn, _, err := getConnection(flags, instance)
+
+	if err != nil {
+		return []string{}, err
+	}
+
+	if IsPrivateConnection(conn) {
+		return getBastionProxy(flags, instance)
+	}
+
+	if conn != "SSM" {
+		return []string{}, nil
+	}
+
+	if !config.IsSSMPossible() {
+		return nil, usageError("session-manager-plugin must be installed to connect via SSM")
+	}
+
+	proxy, err := ssmProxyCommand(instance.Profile, instance.Region)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{"-o", fmt.Sprintf("ProxyCommand=%s", proxy)}, nil
+}
+
+// RunSessionManagerPlugin starts an SSM session and hands its streams over to session-manager-plugin.
+func RunSessionManagerPlugin(profile string, region string, target string, document string, parameters map[string]string) error {
+	return runSessionManagerPlugin(profile, region, target, document, parameters, nil)
+}
+
+// runSessionManagerPlugin writes the plugin's output to stdout when set, otherwise it goes to the terminal.
+func runSessionManagerPlugin(profile string, region string, target string, document string, parameters map[string]string, stdout io.Writer) error {
+	session, err := inst.GetSession(profile, region)
+
+	if err != nil {
+		return err
+	}
+
+	ssmSession, err := inst.StartSSMSession(session, target, document, parameters)
+
+	if err != nil {
+		return err
+	}
+
+	output, _ := json.Marshal(ssmSession.Output)
+	input, _ := json.Marshal(ssmSession.Input)
+
+	cmd := exec.Command("session-manager-plugin", string(output), ssmSession.Region, "StartSession", profile, string(input), ssmSession.Endpoint)
+
+	cmd.Stdout = stdout
+
+	if err := runCommand(cmd); err != nil {
+		inst.TerminateSSMSession(session, aws.StringValue(ssmSession.Output.SessionId))
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,290 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/spf13/pflag"
+)
+
+// Output of remote commands is written through these, so it can be captured.
+var (
+	stdoutWriter io.Writer = os.Stdout
+	stderrWriter io.Writer = os.Stderr
+)
+
+// RemoteTarget is an instance along with the key used to log into it. With EIC set, an ephemeral key is pushed
+// right before connecting instead, as pushed keys are only valid for 60 seconds.
+type RemoteTarget struct {
+	Instance *inst.Instance
+	Key      string
+	EIC      bool
+}
+
+// RemoteResult is the outcome of running a command on a target.
+// Err is only set when ssh couldn't be run, otherwise Code is its exit status.
+type RemoteResult struct {
+	Target RemoteTarget
+	Label  string
+	Code   int
+	Err    error
+}
+
+func (r RemoteResult) Failed() bool {
+	return r.Err != nil || r.Code != 0
+}
+
+// ReachedRemote reports whether ssh connected and authenticated, regardless of how the command exited.
+func (r RemoteResult) ReachedRemote() bool {
+	return r.Err == nil && r.Code != sshErrorStatus
+}
+
+// GetRemoteCommand returns the command following --, or a shell reading --script from stdin with the command as arguments.
+func GetRemoteCommand(flags *pflag.FlagSet, args []string) ([]string, []byte, error) {
+	script, _ := flags.GetString("script")
+
+	if script == "" {
+		if len(args) == 0 {
+			return nil, nil, usageError("Specify a command after -- or pass --script")
+		}
+
+		return args, nil, nil
+	}
+
+	data, err := ioutil.ReadFile(script)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append([]string{"sh", "-s", "--"}, args...), data, nil
+}
+
+// pushTargetKey pushes the EC2 Instance Connect key of an EIC target, setting it as the target's key.
+func pushTargetKey(flags *pflag.FlagSet, target *RemoteTarget) error {
+	if !target.EIC {
+		return nil
+	}
+
+	key, err := PushEICKey(flags, target.Instance)
+
+	target.Key = key
+
+	return err
+}
+
+// generateRemoteCmd appends the command after the target, where like ssh it is interpreted by the remote shell.
+func generateRemoteCmd(flags *pflag.FlagSet, target RemoteTarget, command []string, extra ...string) (string, []string, error) {
+	base, components, err := generateCmd(flags, target.Instance, target.Key, extra...)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return base, append(components, strings.Join(command, " ")), nil
+}
+
+// RunRemote runs the command on a single target attached to the terminal.
+func RunRemote(flags *pflag.FlagSet, target RemoteTarget, command []string, script []byte) error {
+	if err := pushTargetKey(flags, &target); err != nil {
+		return err
+	}
+
+	base, components, err := generateRemoteCmd(flags, target, command)
+
+	if err != nil {
+		return err
+	}
+
+	if dryRun, _ := flags.GetBool("dryRun"); dryRun {
+		explainConnection(flags, target.Instance)
+
+		return nil
+	}
+
+	cmd := exec.Command(base, components...)
+
+	if script != nil {
+		cmd.Stdin = bytes.NewReader(script)
+	}
+
+	return runCommand(cmd)
+}
+
+// prefixWriter prefixes every line written with a label, holding partial lines until they're complete.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+
+		if idx < 0 {
+			break
+		}
+
+		w.writeLine(w.buf[:idx+1])
+		w.buf = w.buf[idx+1:]
+	}
+
+	return len(p), nil
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	fmt.Fprintf(w.out, "%s%s", w.prefix, line)
+}
+
+// Flush writes any trailing output that didn't end in a newline.
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func runPrefixed(flags *pflag.FlagSet, target RemoteTarget, label string, prefix string, mu *sync.Mutex, command []string, script []byte) RemoteResult {
+	err := pushTargetKey(flags, &target)
+
+	result := RemoteResult{Target: target, Label: label}
+
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	// Nobody can answer a password or host key prompt, so it fails the instance instead of hanging it
+	base, components, err := generateRemoteCmd(flags, target, command, "-o", "BatchMode=yes")
+
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if dryRun, _ := flags.GetBool("dryRun"); dryRun {
+		explainConnection(flags, target.Instance)
+
+		return result
+	}
+
+	stdout := &prefixWriter{mu: mu, out: stdoutWriter, prefix: prefix}
+	stderr := &prefixWriter{mu: mu, out: stderrWriter, prefix: prefix}
+
+	cmd := exec.Command(base, components...)
+
+	// Parallel commands never read the terminal, only the script if there is one
+	cmd.Stdin = bytes.NewReader(script)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+
+	stdout.Flush()
+	stderr.Flush()
+
+	if code, ok := ExitCode(err); ok {
+		result.Code = code
+	} else {
+		result.Err = err
+	}
+
+	return result
+}
+
+func getPrefixes(labels []string) []string {
+	width := 0
+
+	for _, label := range labels {
+		if len(label) > width {
+			width = len(label)
+		}
+	}
+
+	prefixes := []string{}
+
+	for _, label := range labels {
+		prefixes = append(prefixes, fmt.Sprintf("%-*s | ", width, label))
+	}
+
+	return prefixes
+}
+
+// RunRemoteParallel runs the command on every target, at most concurrency at a time, prefixing output with each instance's label.
+// ssh runs in batch mode, so unknown host keys fail unless trusted with -o StrictHostKeyChecking=accept-new.
+func RunRemoteParallel(flags *pflag.FlagSet, targets []RemoteTarget, command []string, script []byte, concurrency int) ([]RemoteResult, error) {
+	if concurrency < 1 {
+		return nil, usageError("--concurrency must be at least 1")
+	}
+
+	instances := []inst.Instance{}
+
+	for _, target := range targets {
+		instances = append(instances, *target.Instance)
+	}
+
+	labels, err := renderInstanceLabels(&instances)
+
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := getPrefixes(labels)
+
+	results := make([]RemoteResult, len(targets))
+	slots := make(chan struct{}, concurrency)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for idx, target := range targets {
+		wg.Add(1)
+
+		go func(idx int, target RemoteTarget) {
+			defer wg.Done()
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			results[idx] = runPrefixed(flags, target, labels[idx], prefixes[idx], &mu, command, script)
+		}(idx, target)
+	}
+
+	wg.Wait()
+
+	return results, nil
+}
+
+// PrintRemoteSummary writes a table of the exit status on every instance.
+func PrintRemoteSummary(out io.Writer, results []RemoteResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "INSTANCE\tEXIT\t")
+
+	for _, result := range results {
+		status := fmt.Sprint(result.Code)
+
+		if result.Err != nil {
+			status = fmt.Sprintf("error: %s", result.Err)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t\n", result.Label, status)
+	}
+
+	w.Flush()
+}
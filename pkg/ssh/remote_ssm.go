@@ -0,0 +1,324 @@
+package ssh
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/spf13/pflag"
+)
+
+var (
+	ssmPollInterval = 2 * time.Second
+
+	// ssmNow and notifyInterrupt are swapped out in tests, which can't wait on SSM timeouts or be interrupted
+	ssmNow          = time.Now
+	notifyInterrupt = notifySignals
+)
+
+// scriptDelimiter ends the heredoc a script is embedded in, so it can't clash with the script's own content.
+const scriptDelimiter = "AWSSH_SCRIPT_EOF"
+
+// getSSMTimeout is how long a command sent through SSM may take on each instance before it's reported as failed.
+func getSSMTimeout(flags *pflag.FlagSet) (time.Duration, error) {
+	timeout, _ := flags.GetDuration("timeout")
+
+	if timeout < time.Second {
+		return 0, usageError("--timeout must be at least 1s")
+	}
+
+	return timeout, nil
+}
+
+// ViaSSM reports whether commands are sent through SSM Run Command instead of ssh.
+func ViaSSM(flags *pflag.FlagSet) bool {
+	via, _ := flags.GetString("via")
+
+	return via == "ssm"
+}
+
+// ssmOnline reports whether Run Command can reach the instance, which unlike SSH sessions works with any agent version.
+func ssmOnline(instance inst.Instance) bool {
+	return instance.SSMPingStatus == ssm.PingStatusOnline
+}
+
+// getShellCommands builds the script run by AWS-RunShellScript, embedding a --script like ssh pipes it to sh -s.
+func getShellCommands(command []string, script []byte) []string {
+	line := strings.Join(command, " ")
+
+	if script == nil {
+		return []string{line}
+	}
+
+	return []string{
+		fmt.Sprintf("%s <<'%s'", line, scriptDelimiter),
+		strings.TrimSuffix(string(script), "\n"),
+		scriptDelimiter,
+	}
+}
+
+// getInvocationCode mirrors ssh, using the command's status and 255 when it never completed.
+func getInvocationCode(invocation *inst.CommandInvocation) int {
+	switch invocation.Status {
+	case ssm.CommandInvocationStatusSuccess:
+		return int(invocation.ResponseCode)
+	case ssm.CommandInvocationStatusFailed:
+		if invocation.ResponseCode > 0 {
+			return int(invocation.ResponseCode)
+		}
+	}
+
+	return sshErrorStatus
+}
+
+type ssmTarget struct {
+	idx       int
+	svc       ssmiface.SSMAPI
+	commandId string
+	sentAt    time.Time
+}
+
+// notifySignals closes the returned channel once awssh is asked to stop, until the returned function is called.
+func notifySignals() (<-chan struct{}, func()) {
+	signals := make(chan os.Signal, 1)
+	interrupted := make(chan struct{})
+	done := make(chan struct{})
+
+	signal.Notify(signals, stopSignals...)
+
+	go func() {
+		select {
+		case <-signals:
+			close(interrupted)
+		case <-done:
+		}
+	}()
+
+	return interrupted, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// sendSSMCommands sends the command once per scope, in batches SendCommand accepts.
+func sendSSMCommands(clients inst.ClientProvider, targets []RemoteTarget, commands []string, timeout time.Duration, results []RemoteResult) []ssmTarget {
+	scopes, order := map[string][]int{}, []string{}
+
+	for idx, target := range targets {
+		key := fmt.Sprintf("%s/%s", target.Instance.Profile, target.Instance.Region)
+
+		if _, found := scopes[key]; !found {
+			order = append(order, key)
+		}
+
+		scopes[key] = append(scopes[key], idx)
+	}
+
+	sent := []ssmTarget{}
+
+	for _, key := range order {
+		indexes := scopes[key]
+		instance := targets[indexes[0]].Instance
+
+		scope, err := inst.NewScope(instance.Profile, instance.Region)
+
+		if err != nil {
+			for _, idx := range indexes {
+				results[idx].Err = err
+			}
+
+			continue
+		}
+
+		svc := clients.SSM(scope)
+
+		for start := 0; start < len(indexes); start += inst.SendCommandLimit {
+			end := start + inst.SendCommandLimit
+
+			if end > len(indexes) {
+				end = len(indexes)
+			}
+
+			batch := indexes[start:end]
+			ids := []string{}
+
+			for _, idx := range batch {
+				ids = append(ids, targets[idx].Instance.InstanceId)
+			}
+
+			commandId, err := inst.SendShellCommand(svc, ids, commands, timeout)
+			sentAt := ssmNow()
+
+			for _, idx := range batch {
+				if err != nil {
+					results[idx].Err = err
+					continue
+				}
+
+				sent = append(sent, ssmTarget{idx: idx, svc: svc, commandId: commandId, sentAt: sentAt})
+			}
+		}
+	}
+
+	return sent
+}
+
+// pollInvocation writes output as it grows until the invocation completes. An invocation that never registers
+// or doesn't finish in time, such as on an unreachable agent, fails like ssh with 255 and is cancelled,
+// as the agent would otherwise still run it when it comes back. SSM allows the agent to pick the command up
+// within the delivery timeout and then run it for timeout, so that's how long it's waited for.
+func pollInvocation(target ssmTarget, instanceId string, stdout *prefixWriter, stderr *prefixWriter, timeout time.Duration, interrupted <-chan struct{}) (int, error) {
+	wait := inst.GetDeliveryTimeout(timeout) + timeout
+	deadline := target.sentAt.Add(wait)
+
+	written := map[*prefixWriter]int{}
+
+	write := func(w *prefixWriter, content string) {
+		if len(content) > written[w] {
+			w.Write([]byte(content[written[w]:]))
+			written[w] = len(content)
+		}
+	}
+
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	for {
+		invocation, err := inst.GetCommandInvocation(target.svc, target.commandId, instanceId)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if invocation != nil {
+			write(stdout, invocation.Stdout)
+			write(stderr, invocation.Stderr)
+
+			if invocation.Done() {
+				if invocation.Status != ssm.CommandInvocationStatusSuccess && invocation.Status != ssm.CommandInvocationStatusFailed {
+					stderr.Write([]byte(fmt.Sprintf("SSM command %s\n", invocation.Status)))
+				}
+
+				return getInvocationCode(invocation), nil
+			}
+		}
+
+		if ssmNow().After(deadline) {
+			stderr.Write([]byte(fmt.Sprintf("SSM command didn't complete within %s, cancelling it\n", wait)))
+
+			if err := inst.CancelCommand(target.svc, target.commandId, []string{instanceId}); err != nil {
+				stderr.Write([]byte(fmt.Sprintf("Failed to cancel SSM command %s: %s\n", target.commandId, err)))
+			}
+
+			return sshErrorStatus, nil
+		}
+
+		select {
+		case <-interrupted:
+			return sshErrorStatus, nil
+		case <-time.After(ssmPollInterval):
+		}
+	}
+}
+
+// cancelSSMCommands cancels every command sent, on the instances where it's still outstanding.
+func cancelSSMCommands(sent []ssmTarget) {
+	cancelled := map[string]bool{}
+
+	for _, target := range sent {
+		if cancelled[target.commandId] {
+			continue
+		}
+
+		cancelled[target.commandId] = true
+
+		if err := inst.CancelCommand(target.svc, target.commandId, nil); err != nil {
+			fmt.Fprintf(stderrWriter, "Failed to cancel SSM command %s: %s\n", target.commandId, err)
+		}
+	}
+}
+
+// RunRemoteSSM sends the command through SSM Run Command, streaming each instance's output like RunRemoteParallel.
+// Output is only prefixed when running on more than one instance.
+func RunRemoteSSM(clients inst.ClientProvider, flags *pflag.FlagSet, targets []RemoteTarget, command []string, script []byte) ([]RemoteResult, error) {
+	commands := getShellCommands(command, script)
+
+	timeout, err := getSSMTimeout(flags)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println(inst.ShellScriptDocument, strings.Join(commands, "\n"))
+
+	if dryRun, _ := flags.GetBool("dryRun"); dryRun {
+		return []RemoteResult{}, nil
+	}
+
+	instances := []inst.Instance{}
+
+	for _, target := range targets {
+		instances = append(instances, *target.Instance)
+	}
+
+	labels, err := renderInstanceLabels(&instances)
+
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make([]string, len(labels))
+
+	if len(labels) > 1 {
+		prefixes = getPrefixes(labels)
+	}
+
+	results := make([]RemoteResult, len(targets))
+
+	for idx, target := range targets {
+		results[idx] = RemoteResult{Target: target, Label: labels[idx]}
+	}
+
+	interrupted, stop := notifyInterrupt()
+	defer stop()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	sent := sendSSMCommands(clients, targets, commands, timeout, results)
+
+	for _, target := range sent {
+		wg.Add(1)
+
+		go func(target ssmTarget) {
+			defer wg.Done()
+
+			stdout := &prefixWriter{mu: &mu, out: stdoutWriter, prefix: prefixes[target.idx]}
+			stderr := &prefixWriter{mu: &mu, out: stderrWriter, prefix: prefixes[target.idx]}
+
+			code, err := pollInvocation(target, targets[target.idx].Instance.InstanceId, stdout, stderr, timeout, interrupted)
+
+			results[target.idx].Code = code
+			results[target.idx].Err = err
+		}(target)
+	}
+
+	wg.Wait()
+
+	select {
+	case <-interrupted:
+		cancelSSMCommands(sent)
+
+		return results, fmt.Errorf("%w, SSM commands were cancelled", ErrInterrupted)
+	default:
+	}
+
+	return results, nil
+}
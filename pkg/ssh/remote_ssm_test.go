@@ -0,0 +1,267 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/instances/fake"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func newInvocation(status string, code int64, stdout string) *ssm.GetCommandInvocationOutput {
+	return &ssm.GetCommandInvocationOutput{
+		Status:                aws.String(status),
+		ResponseCode:          aws.Int64(code),
+		StandardOutputContent: aws.String(stdout),
+		StandardErrorContent:  aws.String(""),
+	}
+}
+
+func captureOutput(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var out bytes.Buffer
+
+	stdout, stderr := stdoutWriter, stderrWriter
+	stdoutWriter, stderrWriter = &out, &out
+
+	t.Cleanup(func() {
+		stdoutWriter, stderrWriter = stdout, stderr
+	})
+
+	return &out
+}
+
+func TestRunRemoteSSM(t *testing.T) {
+	setupConfig(t)
+	out := captureOutput(t)
+
+	interval := ssmPollInterval
+	ssmPollInterval = 0
+
+	t.Cleanup(func() { ssmPollInterval = interval })
+
+	client := &fake.SSM{
+		Invocations: map[string][]*ssm.GetCommandInvocationOutput{
+			"i-web": {
+				newInvocation(ssm.CommandInvocationStatusInProgress, -1, "starting\n"),
+				newInvocation(ssm.CommandInvocationStatusSuccess, 0, "starting\ndone\n"),
+			},
+			"i-db": {
+				newInvocation(ssm.CommandInvocationStatusFailed, 2, "failing\n"),
+			},
+			"i-old": {
+				newInvocation(ssm.CommandInvocationStatusTimedOut, -1, ""),
+			},
+		},
+	}
+
+	provider := &fake.Provider{SSMClients: map[string]*fake.SSM{"us-east-1": client}}
+
+	targets := []RemoteTarget{}
+
+	for _, name := range []string{"web", "db", "old"} {
+		instance := &inst.Instance{
+			InstanceId: "i-" + name,
+			Region:     "us-east-1",
+			Tags:       map[string]string{"Name": name},
+		}
+
+		targets = append(targets, RemoteTarget{Instance: instance})
+	}
+
+	results, err := RunRemoteSSM(provider, newFlags(t), targets, []string{"uptime"}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes := []int{}
+
+	for _, result := range results {
+		codes = append(codes, result.Code)
+	}
+
+	if !reflect.DeepEqual(codes, []int{0, 2, 255}) {
+		t.Errorf("unexpected exit codes %v", codes)
+	}
+
+	if len(client.Commands) != 1 || len(client.Commands[0].InstanceIds) != 3 {
+		t.Fatalf("expected a single command sent to every instance, got %v", client.Commands)
+	}
+
+	if document := aws.StringValue(client.Commands[0].DocumentName); document != inst.ShellScriptDocument {
+		t.Errorf("unexpected document %s", document)
+	}
+
+	for _, line := range []string{"web [i-web] | starting\n", "web [i-web] | done\n", "db [i-db]   | failing\n", "old [i-old] | SSM command TimedOut\n"} {
+		if strings.Count(out.String(), line) != 1 {
+			t.Errorf("expected output to contain %q once, got\n%s", line, out.String())
+		}
+	}
+}
+
+// fakeSSMClock advances the time seen while polling SSM by step on every reading.
+func fakeSSMClock(t *testing.T, step time.Duration) {
+	t.Helper()
+
+	var mu sync.Mutex
+
+	now, clock := time.Now(), ssmNow
+
+	ssmNow = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now = now.Add(step)
+
+		return now
+	}
+
+	t.Cleanup(func() { ssmNow = clock })
+}
+
+func TestRunRemoteSSMTimeout(t *testing.T) {
+	setupConfig(t)
+	out := captureOutput(t)
+	fakeSSMClock(t, 10*time.Second)
+
+	interval := ssmPollInterval
+	ssmPollInterval = 0
+
+	t.Cleanup(func() { ssmPollInterval = interval })
+
+	// i-stuck never leaves Pending and i-lost never registers an invocation
+	client := &fake.SSM{
+		Invocations: map[string][]*ssm.GetCommandInvocationOutput{
+			"i-stuck": {newInvocation(ssm.CommandInvocationStatusPending, -1, "")},
+		},
+	}
+
+	provider := &fake.Provider{SSMClients: map[string]*fake.SSM{"us-east-1": client}}
+
+	targets := []RemoteTarget{}
+
+	for _, id := range []string{"i-stuck", "i-lost"} {
+		targets = append(targets, RemoteTarget{Instance: &inst.Instance{InstanceId: id, Region: "us-east-1"}})
+	}
+
+	results, err := RunRemoteSSM(provider, newFlags(t, "--timeout", "1s"), targets, []string{"uptime"}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range results {
+		if result.Err != nil || result.Code != 255 {
+			t.Errorf("expected %s to fail with 255, got %d, %v", result.Target.Instance.InstanceId, result.Code, result.Err)
+		}
+	}
+
+	if count := strings.Count(out.String(), "SSM command didn't complete within 31s, cancelling it"); count != 2 {
+		t.Errorf("expected both instances to report the timeout, got\n%s", out.String())
+	}
+
+	cancelled := []string{}
+
+	for _, input := range client.Cancelled {
+		if aws.StringValue(input.CommandId) != "command-1" {
+			t.Errorf("unexpected command cancelled %s", aws.StringValue(input.CommandId))
+		}
+
+		cancelled = append(cancelled, aws.StringValueSlice(input.InstanceIds)...)
+	}
+
+	sort.Strings(cancelled)
+
+	if !reflect.DeepEqual(cancelled, []string{"i-lost", "i-stuck"}) {
+		t.Errorf("expected the command to be cancelled on both instances, got %v", cancelled)
+	}
+
+	if timeout := aws.Int64Value(client.Commands[0].TimeoutSeconds); timeout != 30 {
+		t.Errorf("expected the minimum delivery timeout, got %d", timeout)
+	}
+
+	if timeout := aws.StringValueSlice(client.Commands[0].Parameters["executionTimeout"]); !reflect.DeepEqual(timeout, []string{"1"}) {
+		t.Errorf("unexpected execution timeout %v", timeout)
+	}
+}
+
+func TestRunRemoteSSMInterrupted(t *testing.T) {
+	setupConfig(t)
+	captureOutput(t)
+
+	notify := notifyInterrupt
+	notifyInterrupt = func() (<-chan struct{}, func()) {
+		interrupted := make(chan struct{})
+		close(interrupted)
+
+		return interrupted, func() {}
+	}
+
+	t.Cleanup(func() { notifyInterrupt = notify })
+
+	client := &fake.SSM{
+		Invocations: map[string][]*ssm.GetCommandInvocationOutput{
+			"i-a": {newInvocation(ssm.CommandInvocationStatusInProgress, -1, "")},
+			"i-b": {newInvocation(ssm.CommandInvocationStatusInProgress, -1, "")},
+		},
+	}
+
+	provider := &fake.Provider{SSMClients: map[string]*fake.SSM{"us-east-1": client}}
+
+	targets := []RemoteTarget{}
+
+	for _, id := range []string{"i-a", "i-b"} {
+		targets = append(targets, RemoteTarget{Instance: &inst.Instance{InstanceId: id, Region: "us-east-1"}})
+	}
+
+	if _, err := RunRemoteSSM(provider, newFlags(t), targets, []string{"sleep", "600"}, nil); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("expected ErrInterrupted, got %v", err)
+	}
+
+	if len(client.Cancelled) != 1 || aws.StringValue(client.Cancelled[0].CommandId) != "command-1" || len(client.Cancelled[0].InstanceIds) != 0 {
+		t.Errorf("expected the command to be cancelled once on every instance, got %v", client.Cancelled)
+	}
+}
+
+func TestSSMOnline(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance inst.Instance
+		expected bool
+	}{
+		{"online", inst.Instance{SSMPingStatus: ssm.PingStatusOnline, SSMAgentVersion: "3.1.501.0", SSMEnabled: true}, true},
+		{"agent too old for ssh", inst.Instance{SSMPingStatus: ssm.PingStatusOnline, SSMAgentVersion: "2.3.50.0"}, true},
+		{"connection lost", inst.Instance{SSMPingStatus: ssm.PingStatusConnectionLost, SSMAgentVersion: "3.1.501.0"}, false},
+		{"unknown to ssm", inst.Instance{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := ssmOnline(tt.instance); actual != tt.expected {
+				t.Errorf("ssmOnline() = %t, expected %t", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetShellCommands(t *testing.T) {
+	if actual := getShellCommands([]string{"uptime", "-p"}, nil); !reflect.DeepEqual(actual, []string{"uptime -p"}) {
+		t.Errorf("unexpected commands %q", actual)
+	}
+
+	expected := []string{"sh -s -- v1 <<'AWSSH_SCRIPT_EOF'", "echo $1", "AWSSH_SCRIPT_EOF"}
+
+	if actual := getShellCommands([]string{"sh", "-s", "--", "v1"}, []byte("echo $1\n")); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected script commands %q", actual)
+	}
+}
@@ -0,0 +1,175 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+
+	w := &prefixWriter{mu: &sync.Mutex{}, out: &out, prefix: "web-1 | "}
+
+	w.Write([]byte("first\nsec"))
+	w.Write([]byte("ond\nthird"))
+
+	if out.String() != "web-1 | first\nweb-1 | second\n" {
+		t.Errorf("unexpected output before flush %q", out.String())
+	}
+
+	w.Flush()
+
+	if !strings.HasSuffix(out.String(), "web-1 | third\n") {
+		t.Errorf("expected trailing output after flush, got %q", out.String())
+	}
+}
+
+func TestGetPrefixes(t *testing.T) {
+	expected := []string{"web-1     | ", "db-backup | "}
+
+	if actual := getPrefixes([]string{"web-1", "db-backup"}); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("getPrefixes() = %q, expected %q", actual, expected)
+	}
+}
+
+func TestGetRemoteCommand(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "deploy.sh")
+
+	if err := ioutil.WriteFile(script, []byte("echo $1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	command, stdin, err := GetRemoteCommand(newFlags(t), []string{"uptime"})
+
+	if err != nil || !reflect.DeepEqual(command, []string{"uptime"}) || stdin != nil {
+		t.Errorf("unexpected command %v, %q, %v", command, stdin, err)
+	}
+
+	flags := newFlags(t)
+	flags.String("script", "", "")
+	flags.Set("script", script)
+
+	command, stdin, err = GetRemoteCommand(flags, []string{"v1.2.3"})
+
+	if err != nil || !reflect.DeepEqual(command, []string{"sh", "-s", "--", "v1.2.3"}) || string(stdin) != "echo $1\n" {
+		t.Errorf("unexpected script command %v, %q, %v", command, stdin, err)
+	}
+
+	if _, _, err := GetRemoteCommand(newFlags(t), nil); !errors.Is(err, ErrUsage) {
+		t.Errorf("expected ErrUsage without a command, got %v", err)
+	}
+}
+
+// fakeSSH puts an ssh on the PATH failing with status 3 for 10.0.0.2, and 255 for 10.0.0.3.
+func fakeSSH(t *testing.T) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ssh requires a POSIX shell")
+	}
+
+	dir := t.TempDir()
+
+	script := `#!/bin/sh
+case "$*" in
+	*10.0.0.2*) exit 3 ;;
+	*10.0.0.3*) exit 255 ;;
+esac
+echo "ran on $*"
+`
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRunRemoteParallel(t *testing.T) {
+	setupConfig(t)
+	fakeSSH(t)
+
+	out := captureOutput(t)
+
+	targets := []RemoteTarget{}
+
+	for idx, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		instance := &inst.Instance{
+			InstanceId:       "i-" + string(rune('a'+idx)),
+			PrivateIpAddress: ip,
+			Tags:             map[string]string{"Name": "web"},
+		}
+
+		targets = append(targets, RemoteTarget{Instance: instance, Key: "key.pem"})
+	}
+
+	results, err := RunRemoteParallel(newFlags(t), targets, []string{"uptime"}, nil, 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes := []int{}
+	reached := []bool{}
+
+	for _, result := range results {
+		codes = append(codes, result.Code)
+		reached = append(reached, result.ReachedRemote())
+	}
+
+	if !reflect.DeepEqual(codes, []int{0, 3, 255}) {
+		t.Errorf("unexpected exit codes %v", codes)
+	}
+
+	if !reflect.DeepEqual(reached, []bool{true, true, false}) {
+		t.Errorf("unexpected reached remote %v", reached)
+	}
+
+	if !strings.Contains(out.String(), "ran on -i key.pem -p 22 -l ec2-user -o BatchMode=yes 10.0.0.1 uptime") {
+		t.Errorf("expected ssh to run in batch mode, got\n%s", out.String())
+	}
+
+	var summary bytes.Buffer
+
+	PrintRemoteSummary(&summary, results)
+
+	if !strings.Contains(summary.String(), "web [i-b]  3") {
+		t.Errorf("expected summary to list exit codes, got\n%s", summary.String())
+	}
+
+	if _, err := RunRemoteParallel(newFlags(t), targets, []string{"uptime"}, nil, 0); !errors.Is(err, ErrUsage) {
+		t.Errorf("expected ErrUsage for zero concurrency, got %v", err)
+	}
+}
+
+func TestRunRemoteParallelPushesEICKeys(t *testing.T) {
+	setupConfig(t)
+
+	targets := []RemoteTarget{}
+
+	for _, id := range []string{"i-a", "i-b"} {
+		targets = append(targets, RemoteTarget{Instance: &inst.Instance{InstanceId: id, PrivateIpAddress: "10.0.0.1"}, EIC: true})
+	}
+
+	results, err := RunRemoteParallel(newFlags(t, "--dryRun", "-l", "ec2-user"), targets, []string{"uptime"}, nil, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range results {
+		if result.Err != nil || result.Target.Key != GetEICKeyPath() {
+			t.Errorf("expected %s to push its own key, got %q, %v", result.Target.Instance.InstanceId, result.Target.Key, result.Err)
+		}
+	}
+}
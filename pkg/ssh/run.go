@@ -2,78 +2,55 @@ package ssh
 
 import (
 	"log"
-	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 
-	"github.com/JFenstermacher/awssh/pkg/config"
 	inst "github.com/JFenstermacher/awssh/pkg/instances"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
-func GetLoginName(flags *pflag.FlagSet) []string {
-	loginName, _ := flags.GetString("loginName")
+func getLoginName(flags *pflag.FlagSet, instance *inst.Instance) (string, error) {
+	loginName, _, err := resolveLoginName(flags, instance)
 
-	if loginName == "" {
-		loginName = config.GetDefaultUser()
-	}
+	return loginName, err
+}
+
+func GetLoginName(flags *pflag.FlagSet, instance *inst.Instance) ([]string, error) {
+	loginName, err := getLoginName(flags, instance)
 
-	if loginName == "" {
-		log.Fatal("No login name found. Reinitialize CLI.")
+	if err != nil {
+		return nil, err
 	}
 
-	return []string{"-l", loginName}
+	return []string{"-l", loginName}, nil
 }
 
-func GetTarget(flags *pflag.FlagSet, instance *inst.Instance) string {
-	conns := config.GetConnectionOrder()
+func getConnection(flags *pflag.FlagSet, instance *inst.Instance) (string, string, error) {
+	resolution, err := ResolveTarget(flags, instance)
 
-	if len(conns) == 0 {
-		log.Fatal("No connections in ConnectionOrder. Reinitialize CLI.")
+	if err != nil {
+		return "", "", err
 	}
 
-	if ssm, _ := flags.GetBool("ssm"); ssm {
-		return instance.InstanceId
-	}
+	return resolution.Conn, resolution.Target, nil
+}
 
-	if pub, _ := flags.GetBool("pub"); pub {
-		return instance.PublicIpAddress
-	}
+func GetTarget(flags *pflag.FlagSet, instance *inst.Instance) (string, error) {
+	_, target, err := getConnection(flags, instance)
 
-	if priv, _ := flags.GetBool("priv"); priv {
-		return instance.PrivateIpAddress
-	}
+	return target, err
+}
 
-	target := ""
-
-	for _, conn := range conns {
-		switch conn {
-		case "SSM":
-			if instance.SSMEnabled {
-				target = instance.InstanceId
-			}
-		case "PUBLIC":
-			if instance.PublicIpAddress != "" {
-				target = instance.PublicIpAddress
-			}
-		default:
-			target = instance.PrivateIpAddress
-		}
-
-		if target != "" {
-			break
-		}
-	}
+func getPort(flags *pflag.FlagSet) string {
+	port, _ := flags.GetInt("port")
 
-	return target
+	return strconv.Itoa(port)
 }
 
 func GetPort(flags *pflag.FlagSet) []string {
-	port, _ := flags.GetInt("port")
-
-	return []string{"-p", strconv.Itoa(port)}
+	return []string{"-p", getPort(flags)}
 }
 
 func GetOptions(flags *pflag.FlagSet) []string {
@@ -108,31 +85,54 @@ func GetKey(key string) []string {
 	return []string{"-i", key}
 }
 
-func generateCmd(flags *pflag.FlagSet, instance *inst.Instance, key string) (string, []string) {
+// generateCmd builds the ssh command, with any extra arguments placed before the target.
+func generateCmd(flags *pflag.FlagSet, instance *inst.Instance, key string, extra ...string) (string, []string, error) {
 	cmd := "ssh"
 
+	proxy, err := GetProxyCommand(flags, instance)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	login, err := GetLoginName(flags, instance)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	target, err := GetTarget(flags, instance)
+
+	if err != nil {
+		return "", nil, err
+	}
+
 	components := GetBaseFlags()
 	components = append(components, GetOptions(flags)...)
+	components = append(components, proxy...)
 	components = append(components, GetKey(key)...)
 	components = append(components, GetPort(flags)...)
-	components = append(components, GetLoginName(flags)...)
-	components = append(components, GetTarget(flags, instance))
+	components = append(components, login...)
+	components = append(components, extra...)
+	components = append(components, target)
 
 	log.Println(cmd, strings.Join(components, " "))
 
-	return cmd, components
+	return cmd, components, nil
 }
 
-func SSH(flags *pflag.FlagSet, instance *inst.Instance, key string) {
-	base, components := generateCmd(flags, instance, key)
+func SSH(flags *pflag.FlagSet, instance *inst.Instance, key string) error {
+	base, components, err := generateCmd(flags, instance, key)
 
-	cmd := exec.Command(base, components...)
+	if err != nil {
+		return err
+	}
 
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	if dryRun, _ := flags.GetBool("dryRun"); dryRun {
+		explainConnection(flags, instance)
 
-	if err := cmd.Run(); err != nil {
-		log.Fatal(err)
+		return nil
 	}
+
+	return runCommand(exec.Command(base, components...))
 }
@@ -0,0 +1,76 @@
+package ssh
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/spf13/viper"
+)
+
+func TestGetTarget(t *testing.T) {
+	public := &inst.Instance{InstanceId: "i-public", PublicIpAddress: "54.1.2.3", PrivateIpAddress: "10.0.0.1", SSMEnabled: true}
+	private := &inst.Instance{InstanceId: "i-private", PrivateIpAddress: "10.0.0.2"}
+
+	tests := []struct {
+		name     string
+		order    []string
+		args     []string
+		instance *inst.Instance
+		expected string
+	}{
+		{"public first", []string{"PUBLIC", "PRIVATE"}, nil, public, "54.1.2.3"},
+		{"private first", []string{"PRIVATE", "PUBLIC"}, nil, public, "10.0.0.1"},
+		{"falls through to private", []string{"PUBLIC", "PRIVATE"}, nil, private, "10.0.0.2"},
+		{"ssm first", []string{"SSM", "PUBLIC", "PRIVATE"}, nil, public, "i-public"},
+		{"ssm unavailable", []string{"SSM", "PUBLIC", "PRIVATE"}, nil, private, "10.0.0.2"},
+		{"ssm flag", []string{"PUBLIC", "PRIVATE"}, []string{"--ssm"}, public, "i-public"},
+		{"priv flag", []string{"PUBLIC", "PRIVATE"}, []string{"--priv"}, public, "10.0.0.1"},
+		{"pub flag", []string{"PRIVATE", "PUBLIC"}, []string{"--pub"}, public, "54.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfig(t)
+			viper.Set("ConnectionOrder", tt.order)
+
+			actual, err := GetTarget(newFlags(t, tt.args...), tt.instance)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual != tt.expected {
+				t.Errorf("GetTarget() = %q, expected %q", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetTargetErrors(t *testing.T) {
+	private := &inst.Instance{InstanceId: "i-private", PrivateIpAddress: "10.0.0.2"}
+
+	tests := []struct {
+		name     string
+		order    []string
+		args     []string
+		expected error
+	}{
+		{"no public address", []string{"PUBLIC", "PRIVATE"}, []string{"--pub"}, ErrNoTarget},
+		{"no matching connection", []string{"PUBLIC", "SSM"}, nil, ErrNoTarget},
+		{"empty connection order", []string{}, nil, config.ErrConfigMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfig(t)
+			viper.Set("ConnectionOrder", tt.order)
+
+			if _, err := GetTarget(newFlags(t, tt.args...), private); !errors.Is(err, tt.expected) {
+				t.Errorf("GetTarget() error = %v, expected %v", err, tt.expected)
+			}
+		})
+	}
+}
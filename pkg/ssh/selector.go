@@ -0,0 +1,217 @@
+package ssh
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/pflag"
+)
+
+var filterFields = map[string]func(instance inst.Instance) string{
+	"availability-zone":   func(i inst.Instance) string { return i.AvailabilityZone },
+	"image-id":            func(i inst.Instance) string { return i.ImageId },
+	"instance-id":         func(i inst.Instance) string { return i.InstanceId },
+	"instance-state-name": func(i inst.Instance) string { return i.State },
+	"instance-type":       func(i inst.Instance) string { return i.InstanceType },
+	"ip-address":          func(i inst.Instance) string { return i.PublicIpAddress },
+	"key-name":            func(i inst.Instance) string { return i.KeyName },
+	"private-ip-address":  func(i inst.Instance) string { return i.PrivateIpAddress },
+	"ssm-agent-version":   func(i inst.Instance) string { return i.SSMAgentVersion },
+	"ssm-ping-status":     func(i inst.Instance) string { return i.SSMPingStatus },
+	"subnet-id":           func(i inst.Instance) string { return i.SubnetId },
+	"vpc-id":              func(i inst.Instance) string { return i.VpcId },
+}
+
+// ssmFilterPrefix marks filters only known to SSM, applied client-side after listing.
+const ssmFilterPrefix = "ssm-"
+
+// Selector narrows instances by ID or Name, tags and EC2 style filters.
+// Values for the same key are OR'd together, different keys are AND'd.
+type Selector struct {
+	Query    string
+	Tags     map[string][]string
+	Filters  map[string][]string
+	explicit bool
+}
+
+func splitPair(pair string, flag string) (string, string, error) {
+	parts := strings.SplitN(pair, "=", 2)
+
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", usageError("--%s must be in form {key}={value}: [%s] failed.", flag, pair)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func getFilterNames() []string {
+	names := []string{}
+
+	for name := range filterFields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func parseFilters(filters []string, flag string) (map[string][]string, map[string][]string, error) {
+	tags, fields := map[string][]string{}, map[string][]string{}
+
+	for _, filter := range filters {
+		key, value, err := splitPair(filter, flag)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if strings.HasPrefix(key, "tag:") {
+			key = strings.TrimPrefix(key, "tag:")
+			tags[key] = append(tags[key], value)
+			continue
+		}
+
+		if _, found := filterFields[key]; !found {
+			return nil, nil, usageError("Unsupported filter [%s], must be one of: tag:{key}, %s", key, strings.Join(getFilterNames(), ", "))
+		}
+
+		fields[key] = append(fields[key], value)
+	}
+
+	return tags, fields, nil
+}
+
+// mergeDefaults adds default values for any key that wasn't explicitly selected.
+func mergeDefaults(selected map[string][]string, defaults map[string][]string) {
+	for key, values := range defaults {
+		if _, found := selected[key]; !found {
+			selected[key] = values
+		}
+	}
+}
+
+func NewSelector(flags *pflag.FlagSet, query string) (*Selector, error) {
+	filters, _ := flags.GetStringArray("filter")
+	tags, fields, err := parseFilters(filters, "filter")
+
+	if err != nil {
+		return nil, err
+	}
+
+	flagTags, _ := flags.GetStringArray("tag")
+
+	for _, tag := range flagTags {
+		key, value, err := splitPair(tag, "tag")
+
+		if err != nil {
+			return nil, err
+		}
+
+		tags[key] = append(tags[key], value)
+	}
+
+	selector := &Selector{
+		Query:    query,
+		Tags:     tags,
+		Filters:  fields,
+		explicit: query != "" || len(tags) > 0 || len(fields) > 0,
+	}
+
+	defaultTags, defaultFields, err := parseFilters(config.GetDefaultFilters(), "DefaultFilters")
+
+	if err != nil {
+		return nil, err
+	}
+
+	mergeDefaults(selector.Tags, defaultTags)
+	mergeDefaults(selector.Filters, defaultFields)
+
+	return selector, nil
+}
+
+// Empty reports whether the user didn't narrow instances, regardless of the configured default filters.
+func (s *Selector) Empty() bool {
+	return !s.explicit
+}
+
+func newFilter(name string, values []string) *ec2.Filter {
+	return &ec2.Filter{
+		Name:   aws.String(name),
+		Values: aws.StringSlice(values),
+	}
+}
+
+// EC2Filters translates the selector into filters applied by DescribeInstances.
+func (s *Selector) EC2Filters() []*ec2.Filter {
+	filters := []*ec2.Filter{}
+
+	if s.Query != "" {
+		if strings.HasPrefix(s.Query, "i-") {
+			filters = append(filters, newFilter("instance-id", []string{s.Query}))
+		} else {
+			filters = append(filters, newFilter("tag:Name", []string{s.Query}))
+		}
+	}
+
+	for key, values := range s.Tags {
+		filters = append(filters, newFilter("tag:"+key, values))
+	}
+
+	for key, values := range s.Filters {
+		if !strings.HasPrefix(key, ssmFilterPrefix) {
+			filters = append(filters, newFilter(key, values))
+		}
+	}
+
+	return filters
+}
+
+// NeedsSSM reports whether matching requires the SSM status of instances.
+func (s *Selector) NeedsSSM() bool {
+	for key := range s.Filters {
+		if strings.HasPrefix(key, ssmFilterPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchAny reports whether value matches any of the patterns, which may use EC2 style wildcards.
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Selector) Match(instance inst.Instance) bool {
+	if s.Query != "" && !matchAny([]string{s.Query}, instance.InstanceId) && !matchAny([]string{s.Query}, instance.Tags["Name"]) {
+		return false
+	}
+
+	for key, values := range s.Tags {
+		value, found := instance.Tags[key]
+
+		if !found || !matchAny(values, value) {
+			return false
+		}
+	}
+
+	for key, values := range s.Filters {
+		if !matchAny(values, filterFields[key](instance)) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,186 @@
+package ssh
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/spf13/viper"
+)
+
+func TestNewSelectorErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		defaults []string
+	}{
+		{name: "tag without value", args: []string{"--tag", "Env"}},
+		{name: "tag without key", args: []string{"--tag", "=prod"}},
+		{name: "filter without value", args: []string{"--filter", "vpc-id"}},
+		{name: "unsupported filter", args: []string{"--filter", "owner-id=1234"}},
+		{name: "unsupported default filter", defaults: []string{"owner-id=1234"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupConfig(t)
+
+			viper.Set("DefaultFilters", test.defaults)
+
+			if _, err := NewSelector(newFlags(t, test.args...), ""); !errors.Is(err, ErrUsage) {
+				t.Errorf("expected a usage error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewSelectorDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		defaults []string
+		tags     map[string][]string
+		filters  map[string][]string
+		empty    bool
+	}{
+		{
+			name:     "defaults only",
+			defaults: []string{"tag:Team=ops", "instance-state-name=running"},
+			tags:     map[string][]string{"Team": {"ops"}},
+			filters:  map[string][]string{"instance-state-name": {"running"}},
+			empty:    true,
+		},
+		{
+			name:     "explicit keys replace defaults",
+			args:     []string{"--tag", "Team=dev", "--filter", "instance-state-name=stopped", "--filter", "instance-state-name=running"},
+			defaults: []string{"tag:Team=ops", "instance-state-name=running"},
+			tags:     map[string][]string{"Team": {"dev"}},
+			filters:  map[string][]string{"instance-state-name": {"stopped", "running"}},
+		},
+		{
+			name:     "other keys keep defaults",
+			args:     []string{"--filter", "tag:Env=prod", "--filter", "vpc-id=vpc-1"},
+			defaults: []string{"tag:Team=ops", "instance-state-name=running"},
+			tags:     map[string][]string{"Env": {"prod"}, "Team": {"ops"}},
+			filters:  map[string][]string{"vpc-id": {"vpc-1"}, "instance-state-name": {"running"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupConfig(t)
+
+			viper.Set("DefaultFilters", test.defaults)
+
+			selector, err := NewSelector(newFlags(t, test.args...), "")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(selector.Tags, test.tags) || !reflect.DeepEqual(selector.Filters, test.filters) {
+				t.Errorf("expected tags %v and filters %v, got %v and %v", test.tags, test.filters, selector.Tags, selector.Filters)
+			}
+
+			if selector.Empty() != test.empty {
+				t.Errorf("expected Empty() %t, got %t", test.empty, selector.Empty())
+			}
+		})
+	}
+}
+
+func TestSelectorEC2Filters(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector Selector
+		expected map[string][]string
+		ssm      bool
+	}{
+		{
+			name:     "instance id query",
+			selector: Selector{Query: "i-0abc"},
+			expected: map[string][]string{"instance-id": {"i-0abc"}},
+		},
+		{
+			name:     "name query",
+			selector: Selector{Query: "web-*"},
+			expected: map[string][]string{"tag:Name": {"web-*"}},
+		},
+		{
+			name: "ssm filters stay client-side",
+			selector: Selector{
+				Tags:    map[string][]string{"Env": {"prod", "staging"}},
+				Filters: map[string][]string{"vpc-id": {"vpc-1"}, "ssm-ping-status": {"Online"}},
+			},
+			expected: map[string][]string{"tag:Env": {"prod", "staging"}, "vpc-id": {"vpc-1"}},
+			ssm:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := map[string][]string{}
+
+			for _, filter := range test.selector.EC2Filters() {
+				actual[aws.StringValue(filter.Name)] = aws.StringValueSlice(filter.Values)
+			}
+
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+
+			if test.selector.NeedsSSM() != test.ssm {
+				t.Errorf("expected NeedsSSM() %t, got %t", test.ssm, test.selector.NeedsSSM())
+			}
+		})
+	}
+}
+
+func TestSelectorMatch(t *testing.T) {
+	instances := []inst.Instance{
+		{InstanceId: "i-web1", State: "running", SSMPingStatus: "Online", Tags: map[string]string{"Name": "web-1", "Env": "prod"}},
+		{InstanceId: "i-web2", State: "stopped", Tags: map[string]string{"Name": "web-2", "Env": "staging"}},
+		{InstanceId: "i-db1", State: "running", SSMPingStatus: "ConnectionLost", Tags: map[string]string{"Name": "db-1"}},
+	}
+
+	tests := []struct {
+		name     string
+		selector Selector
+		expected []string
+	}{
+		{name: "everything", selector: Selector{}, expected: []string{"i-db1", "i-web1", "i-web2"}},
+		{name: "name wildcard", selector: Selector{Query: "web-*"}, expected: []string{"i-web1", "i-web2"}},
+		{name: "id wildcard", selector: Selector{Query: "i-db?"}, expected: []string{"i-db1"}},
+		{name: "tag values are OR'd", selector: Selector{Tags: map[string][]string{"Env": {"prod", "stag*"}}}, expected: []string{"i-web1", "i-web2"}},
+		{name: "missing tag never matches", selector: Selector{Tags: map[string][]string{"Env": {"*"}}}, expected: []string{"i-web1", "i-web2"}},
+		{
+			name: "keys are AND'd",
+			selector: Selector{
+				Tags:    map[string][]string{"Name": {"*-1"}},
+				Filters: map[string][]string{"instance-state-name": {"running"}, "ssm-ping-status": {"Online"}},
+			},
+			expected: []string{"i-web1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matched := []string{}
+
+			for _, instance := range instances {
+				if test.selector.Match(instance) {
+					matched = append(matched, instance.InstanceId)
+				}
+			}
+
+			sort.Strings(matched)
+
+			if !reflect.DeepEqual(matched, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, matched)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package ssh
+
+import (
+	"os"
+	"syscall"
+)
+
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGWINCH,
+}
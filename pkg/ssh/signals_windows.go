@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package ssh
+
+import "os"
+
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+}
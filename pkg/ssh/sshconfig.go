@@ -0,0 +1,446 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/utils"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// hostMarker starts every managed block, followed by the instance ID, profile and region.
+const hostMarker = "# awssh:"
+
+// noProfile stands in for the default profile, keeping the marker's fields positional
+const noProfile = "-"
+
+const sshConfigHeader = `# Managed by awssh ssh-config generate, changes are overwritten.
+# Include it at the top of ~/.ssh/config with: Include %s
+`
+
+// HostBlock is a Host entry for an instance in the generated ssh config.
+type HostBlock struct {
+	InstanceId string
+	Profile    string
+	Region     string
+	Alias      string
+	Options    []string
+}
+
+func GetSSHConfigPath() string {
+	cachepath := GetCachePath()
+
+	return filepath.Join(cachepath.Dir, "ssh_config")
+}
+
+func (b *HostBlock) inScope(scope *inst.Scope) bool {
+	return b.Profile == scope.Profile && b.Region == scope.Region
+}
+
+func (b *HostBlock) String() string {
+	profile := b.Profile
+
+	if profile == "" {
+		profile = noProfile
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s %s %s %s", hostMarker, b.InstanceId, profile, b.Region),
+		fmt.Sprintf("Host %s", b.Alias),
+	}
+
+	for _, option := range b.Options {
+		lines = append(lines, "    "+option)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// ParseHostBlocks reads the managed blocks back, ignoring anything outside of them.
+func ParseHostBlocks(r io.Reader) ([]*HostBlock, error) {
+	blocks := []*HostBlock{}
+
+	var block *HostBlock
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, hostMarker) {
+			block = nil
+
+			fields := strings.Fields(strings.TrimPrefix(line, hostMarker))
+
+			if len(fields) != 3 {
+				continue
+			}
+
+			block = &HostBlock{InstanceId: fields[0], Profile: fields[1], Region: fields[2]}
+
+			if block.Profile == noProfile {
+				block.Profile = ""
+			}
+
+			blocks = append(blocks, block)
+
+			continue
+		}
+
+		if block == nil || line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "Host ") {
+			block.Alias = strings.TrimSpace(strings.TrimPrefix(line, "Host "))
+		} else {
+			block.Options = append(block.Options, line)
+		}
+	}
+
+	return blocks, scanner.Err()
+}
+
+// ReadHostBlocks reads the blocks of a previously generated file, a missing file has none.
+func ReadHostBlocks(path string) ([]*HostBlock, error) {
+	file, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return []*HostBlock{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return ParseHostBlocks(file)
+}
+
+// sanitizeAlias replaces whitespace and ssh pattern characters, which can't appear in a Host alias.
+func sanitizeAlias(alias string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" \t*?!,", r) {
+			return '-'
+		}
+
+		return r
+	}, strings.TrimSpace(alias))
+}
+
+// quoteConfigValue quotes values with spaces, such as key paths.
+func quoteConfigValue(value string) string {
+	if strings.ContainsAny(value, " \t") {
+		return fmt.Sprintf("%q", value)
+	}
+
+	return value
+}
+
+// getOptionLines turns the ssh arguments for proxying into config options.
+func getOptionLines(args []string) []string {
+	lines := []string{}
+
+	for idx := 0; idx+1 < len(args); idx += 2 {
+		switch args[idx] {
+		case "-J":
+			lines = append(lines, "ProxyJump "+args[idx+1])
+		case "-o":
+			parts := strings.SplitN(args[idx+1], "=", 2)
+
+			if len(parts) == 2 {
+				lines = append(lines, parts[0]+" "+parts[1])
+			}
+		}
+	}
+
+	return lines
+}
+
+func newHostBlock(flags *pflag.FlagSet, instance *inst.Instance, alias string) (*HostBlock, error) {
+	_, target, err := getConnection(flags, instance)
+
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := getLoginName(flags, instance)
+
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := GetProxyCommand(flags, instance)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options := []string{"HostName " + target, "User " + user}
+
+	if key := findKey(inst.DefaultClients, instance); key != "" {
+		options = append(options, "IdentityFile "+quoteConfigValue(key))
+	}
+
+	options = append(options, getOptionLines(proxy)...)
+
+	return &HostBlock{
+		InstanceId: instance.InstanceId,
+		Profile:    instance.Profile,
+		Region:     instance.Region,
+		Alias:      sanitizeAlias(alias),
+		Options:    options,
+	}, nil
+}
+
+func isTerminated(instance inst.Instance) bool {
+	return instance.State == "terminated" || instance.State == "shutting-down"
+}
+
+// mergeHostBlocks replaces the existing blocks of generated instances and drops those in removed,
+// keeping the rest in the order of their aliases. Aliases taken by another instance get its ID appended.
+func mergeHostBlocks(existing []*HostBlock, generated []*HostBlock, removed map[string]bool) []*HostBlock {
+	merged := map[string]*HostBlock{}
+
+	for _, block := range existing {
+		if !removed[block.InstanceId] {
+			merged[block.InstanceId] = block
+		}
+	}
+
+	for _, block := range generated {
+		merged[block.InstanceId] = block
+	}
+
+	blocks := []*HostBlock{}
+
+	for _, block := range merged {
+		blocks = append(blocks, block)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		if blocks[i].Alias != blocks[j].Alias {
+			return blocks[i].Alias < blocks[j].Alias
+		}
+
+		return blocks[i].InstanceId < blocks[j].InstanceId
+	})
+
+	aliases := map[string]bool{}
+
+	for _, block := range blocks {
+		if block.Alias == "" || aliases[block.Alias] {
+			block.Alias = strings.Trim(block.Alias+"-"+block.InstanceId, "-")
+		}
+
+		aliases[block.Alias] = true
+	}
+
+	return blocks
+}
+
+// instanceIdFilterLimit is the most values DescribeInstances accepts in a single filter.
+const instanceIdFilterLimit = 200
+
+// findGoneInstances returns which of the given instances no longer exist in the scope, or are terminated.
+// The listing only filters on the IDs, so instances that merely stopped matching a selector are kept.
+func findGoneInstances(scope *inst.Scope, ids []string) (map[string]bool, error) {
+	gone := map[string]bool{}
+
+	for start := 0; start < len(ids); start += instanceIdFilterLimit {
+		end := start + instanceIdFilterLimit
+
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		instances, err := listScopeInstances(&GetInstancesInput{
+			Filters: []*ec2.Filter{newFilter("instance-id", ids[start:end])},
+		}, scope)
+
+		if err != nil {
+			return nil, err
+		}
+
+		found := map[string]bool{}
+
+		for _, instance := range instances {
+			found[instance.InstanceId] = !isTerminated(instance)
+		}
+
+		for _, id := range ids[start:end] {
+			if !found[id] {
+				gone[id] = true
+			}
+		}
+	}
+
+	return gone, nil
+}
+
+// GenerateHostBlocks lists instances afresh and merges their blocks into the existing ones. Terminated instances are always dropped,
+// with prune so is any other instance from the listed profiles and regions confirmed gone. Scopes that fail to list are left untouched.
+func GenerateHostBlocks(flags *pflag.FlagSet, existing []*HostBlock, prune bool) ([]*HostBlock, error) {
+	selector, err := NewSelector(flags, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, err := GetScopes(flags)
+
+	if err != nil {
+		return nil, err
+	}
+
+	input := newListInput(flags, selector, scopes)
+
+	// The inventory may lag behind EC2, while the generated config outlives any prompt
+	listed := make([][]inst.Instance, len(scopes))
+	errs := make([]error, len(scopes))
+
+	inst.ForEachScope(scopes, func(idx int, scope *inst.Scope) {
+		listed[idx], errs[idx] = listScopeInstances(input, scope)
+	})
+
+	alive := []inst.Instance{}
+	found := map[string]bool{}
+	removed := map[string]bool{}
+
+	for idx, instances := range listed {
+		if errs[idx] != nil {
+			fmt.Fprintf(stderrWriter, "Warning: keeping the hosts of %s, listing its instances failed: %v\n", scopes[idx], errs[idx])
+			continue
+		}
+
+		for _, instance := range instances {
+			found[instance.InstanceId] = true
+
+			if isTerminated(instance) {
+				removed[instance.InstanceId] = true
+			} else if input.Filter(instance) {
+				alive = append(alive, instance)
+			}
+		}
+	}
+
+	aliasTemplate, err := config.GetHostAlias()
+
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := renderLabels(&alive, aliasTemplate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	generated := []*HostBlock{}
+
+	for idx := range alive {
+		block, err := newHostBlock(flags, &alive[idx], aliases[idx])
+
+		if err != nil {
+			fmt.Fprintf(stderrWriter, "Skipping %s: %v\n", alive[idx].InstanceId, err)
+			continue
+		}
+
+		generated = append(generated, block)
+	}
+
+	if prune {
+		for idx, scope := range scopes {
+			if errs[idx] != nil {
+				continue
+			}
+
+			// Instances missing from the selector's listing may only have stopped matching it
+			ids := []string{}
+
+			for _, block := range existing {
+				if block.inScope(scope) && !found[block.InstanceId] {
+					ids = append(ids, block.InstanceId)
+				}
+			}
+
+			if len(ids) == 0 {
+				continue
+			}
+
+			gone, err := findGoneInstances(scope, ids)
+
+			if err != nil {
+				fmt.Fprintf(stderrWriter, "Warning: not pruning the hosts of %s, checking its instances failed: %v\n", scope, err)
+				continue
+			}
+
+			for id := range gone {
+				removed[id] = true
+			}
+		}
+	}
+
+	return mergeHostBlocks(existing, generated, removed), nil
+}
+
+func WriteHostBlocks(w io.Writer, blocks []*HostBlock) error {
+	if _, err := fmt.Fprintf(w, sshConfigHeader, GetSSHConfigPath()); err != nil {
+		return err
+	}
+
+	for _, block := range blocks {
+		if _, err := fmt.Fprintf(w, "\n%s", block); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteSSHConfig replaces the file at path, so ssh never reads a partially written config.
+func WriteSSHConfig(path string, blocks []*HostBlock) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	var config bytes.Buffer
+
+	if err := WriteHostBlocks(&config, blocks); err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(path, config.Bytes(), 0600)
+}
+
+// HasSSHConfigInclude reports whether ~/.ssh/config already includes the generated file.
+func HasSSHConfigInclude() bool {
+	data, err := ioutil.ReadFile(filepath.Join(viper.GetString("HOME"), ".ssh", "config"))
+
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+
+		if len(fields) > 1 && strings.EqualFold(fields[0], "Include") && strings.Contains(line, filepath.Join(".awsshgo", "ssh_config")) {
+			return true
+		}
+	}
+
+	return false
+}
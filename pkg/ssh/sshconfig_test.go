@@ -0,0 +1,194 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/instances/fake"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestHostBlocksRoundTrip(t *testing.T) {
+	setupConfig(t)
+
+	blocks := []*HostBlock{
+		{InstanceId: "i-1", Region: "us-east-1", Alias: "web-1", Options: []string{"HostName 1.1.1.1", "User ec2-user"}},
+		{InstanceId: "i-2", Profile: "prod", Region: "us-west-2", Alias: "db", Options: []string{"HostName i-2", "ProxyCommand aws ssm start-session --target %h"}},
+	}
+
+	out := &bytes.Buffer{}
+
+	if err := WriteHostBlocks(out, blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "# awssh: i-1 - us-east-1\nHost web-1\n    HostName 1.1.1.1\n") {
+		t.Errorf("WriteHostBlocks() wrote:\n%s", out)
+	}
+
+	parsed, err := ParseHostBlocks(out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(parsed, blocks) {
+		t.Errorf("ParseHostBlocks() = %+v, expected %+v", parsed, blocks)
+	}
+}
+
+func TestMergeHostBlocks(t *testing.T) {
+	existing := []*HostBlock{
+		{InstanceId: "i-old", Alias: "old"},
+		{InstanceId: "i-gone", Alias: "gone"},
+		{InstanceId: "i-1", Alias: "web", Options: []string{"HostName 1.1.1.1"}},
+	}
+
+	generated := []*HostBlock{
+		{InstanceId: "i-2", Alias: "web"},
+		{InstanceId: "i-1", Alias: "web", Options: []string{"HostName 2.2.2.2"}},
+		{InstanceId: "i-3"},
+	}
+
+	merged := mergeHostBlocks(existing, generated, map[string]bool{"i-gone": true})
+
+	aliases := []string{}
+
+	for _, block := range merged {
+		aliases = append(aliases, block.InstanceId+"="+block.Alias)
+	}
+
+	expected := []string{"i-3=i-3", "i-old=old", "i-1=web", "i-2=web-i-2"}
+
+	if !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("mergeHostBlocks() = %v, expected %v", aliases, expected)
+	}
+
+	if merged[2].Options[0] != "HostName 2.2.2.2" {
+		t.Errorf("mergeHostBlocks() kept the old block for i-1: %v", merged[2].Options)
+	}
+}
+
+func TestGetOptionLines(t *testing.T) {
+	args := []string{"-J", "ec2-user@1.1.1.1", "-o", "ProxyCommand=ssh -W %h:%p 1.1.1.1"}
+
+	expected := []string{"ProxyJump ec2-user@1.1.1.1", "ProxyCommand ssh -W %h:%p 1.1.1.1"}
+
+	if lines := getOptionLines(args); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("getOptionLines() = %v, expected %v", lines, expected)
+	}
+}
+
+func TestSanitizeAlias(t *testing.T) {
+	if alias := sanitizeAlias(" web server*1 "); alias != "web-server-1" {
+		t.Errorf("sanitizeAlias() = %q", alias)
+	}
+}
+
+func useFakeClients(t *testing.T, provider *fake.Provider) {
+	t.Helper()
+
+	clients := inst.DefaultClients
+	inst.DefaultClients = provider
+
+	t.Cleanup(func() { inst.DefaultClients = clients })
+}
+
+func generateHostBlocks(t *testing.T, existing []*HostBlock, prune bool, args ...string) []string {
+	t.Helper()
+
+	flags := newFlags(t)
+	flags.StringSlice("regions", []string{}, "")
+
+	if err := flags.Parse(append([]string{"-l", "ec2-user"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := GenerateHostBlocks(flags, existing, prune)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := []string{}
+
+	for _, block := range blocks {
+		aliases = append(aliases, block.InstanceId+"="+block.Alias)
+	}
+
+	return aliases
+}
+
+func TestGenerateHostBlocksPrune(t *testing.T) {
+	setupConfig(t)
+	captureOutput(t)
+
+	east := &fake.EC2{
+		Instances: []*ec2.Instance{
+			newEC2Instance("i-web", "web", "running"),
+			newEC2Instance("i-db", "db", "stopped"),
+			newEC2Instance("i-term", "old", "terminated"),
+		},
+	}
+
+	west := &fake.EC2{Err: errors.New("throttled")}
+
+	useFakeClients(t, &fake.Provider{EC2Clients: map[string]*fake.EC2{"us-east-1": east, "us-west-2": west}})
+
+	existing := []*HostBlock{
+		{InstanceId: "i-db", Region: "us-east-1", Alias: "db"},
+		{InstanceId: "i-term", Region: "us-east-1", Alias: "old"},
+		{InstanceId: "i-gone", Region: "us-east-1", Alias: "gone"},
+		{InstanceId: "i-west", Region: "us-west-2", Alias: "west"},
+		{InstanceId: "i-prod", Profile: "prod", Region: "us-east-1", Alias: "prod"},
+	}
+
+	aliases := generateHostBlocks(t, existing, true, "--regions", "us-east-1,us-west-2", "--filter", "instance-state-name=running")
+
+	// i-db only stopped matching the filter and us-west-2 failed to list, neither is pruned
+	expected := []string{"i-db=db", "i-prod=prod", "i-web=web", "i-west=west"}
+
+	if !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("GenerateHostBlocks() = %v, expected %v", aliases, expected)
+	}
+
+	confirm := east.Inputs[len(east.Inputs)-1]
+
+	if len(confirm.Filters) != 1 || confirm.Filters[0].String() != newFilter("instance-id", []string{"i-gone"}).String() {
+		t.Errorf("GenerateHostBlocks() confirmed removals with %v", confirm.Filters)
+	}
+}
+
+func TestGenerateHostBlocksWithoutPrune(t *testing.T) {
+	setupConfig(t)
+	captureOutput(t)
+
+	east := &fake.EC2{
+		Instances: []*ec2.Instance{
+			newEC2Instance("i-web", "web", "running"),
+			newEC2Instance("i-term", "old", "terminated"),
+		},
+	}
+
+	useFakeClients(t, &fake.Provider{EC2Clients: map[string]*fake.EC2{"us-east-1": east}})
+
+	existing := []*HostBlock{
+		{InstanceId: "i-term", Region: "us-east-1", Alias: "old"},
+		{InstanceId: "i-gone", Region: "us-east-1", Alias: "gone"},
+	}
+
+	aliases := generateHostBlocks(t, existing, false, "--regions", "us-east-1")
+
+	// Terminated instances are always dropped, missing ones only with prune
+	if expected := []string{"i-gone=gone", "i-web=web"}; !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("GenerateHostBlocks() = %v, expected %v", aliases, expected)
+	}
+
+	if len(east.Inputs) != 1 {
+		t.Errorf("GenerateHostBlocks() listed %d times without prune", len(east.Inputs))
+	}
+}
@@ -0,0 +1,192 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/spf13/pflag"
+)
+
+// Resolution is the address chosen to reach an instance and the rule that chose it.
+type Resolution struct {
+	Conn    string
+	Target  string
+	Reason  string
+	Skipped []string
+}
+
+// addressResolver returns an address for the connection and where it came from, or an empty address.
+type addressResolver func(instance *inst.Instance) (string, string)
+
+var addressResolvers = map[string]addressResolver{
+	"PUBLIC": func(i *inst.Instance) (string, string) {
+		if i.PublicIpAddress != "" {
+			return i.PublicIpAddress, "primary public IP"
+		}
+
+		return findInterfaceAddress(i, "public IP", func(eni inst.NetworkInterface) []string { return eni.PublicIpAddresses })
+	},
+	"PRIVATE": func(i *inst.Instance) (string, string) {
+		if i.PrivateIpAddress != "" {
+			return i.PrivateIpAddress, "primary private IP"
+		}
+
+		return findInterfaceAddress(i, "private IP", func(eni inst.NetworkInterface) []string { return eni.PrivateIpAddresses })
+	},
+	"PUBLIC_DNS": func(i *inst.Instance) (string, string) {
+		return i.PublicDnsName, "public DNS name"
+	},
+	"PRIVATE_DNS": func(i *inst.Instance) (string, string) {
+		return i.PrivateDnsName, "private DNS name"
+	},
+	"IPV6": func(i *inst.Instance) (string, string) {
+		return findInterfaceAddress(i, "IPv6 address", func(eni inst.NetworkInterface) []string { return eni.Ipv6Addresses })
+	},
+	"ELASTIC_IP": func(i *inst.Instance) (string, string) {
+		return findInterfaceAddress(i, "Elastic IP", func(eni inst.NetworkInterface) []string { return eni.ElasticIpAddresses })
+	},
+	"SSM": func(i *inst.Instance) (string, string) {
+		if i.SSMEnabled {
+			return i.InstanceId, "SSM agent online"
+		}
+
+		return "", ""
+	},
+}
+
+// forcedConnections are the flags choosing a connection regardless of ConnectionOrder.
+var forcedConnections = []struct {
+	Flag string
+	Conn string
+}{
+	{"ssm", "SSM"},
+	{"pub", "PUBLIC"},
+	{"priv", "PRIVATE"},
+}
+
+// getInterfaces orders network interfaces by device index, so the primary interface is tried first.
+func getInterfaces(instance *inst.Instance) []inst.NetworkInterface {
+	enis := append([]inst.NetworkInterface{}, instance.NetworkInterfaces...)
+
+	sort.SliceStable(enis, func(i, j int) bool {
+		return enis[i].DeviceIndex < enis[j].DeviceIndex
+	})
+
+	return enis
+}
+
+func findInterfaceAddress(instance *inst.Instance, kind string, addresses func(eni inst.NetworkInterface) []string) (string, string) {
+	for _, eni := range getInterfaces(instance) {
+		if found := addresses(eni); len(found) > 0 {
+			position := "primary"
+
+			if eni.DeviceIndex != 0 {
+				position = "secondary"
+			}
+
+			return found[0], fmt.Sprintf("%s on %s interface %s", kind, position, eni.NetworkInterfaceId)
+		}
+	}
+
+	return "", ""
+}
+
+// resolveAddress resolves a single connection, failing for connections it doesn't know.
+func resolveAddress(conn string, instance *inst.Instance) (string, string, error) {
+	resolver, found := addressResolvers[conn]
+
+	if !found {
+		return "", "", fmt.Errorf("Unsupported connection [%s] in ConnectionOrder, must be one of: %s", conn, strings.Join(config.Connections, ", "))
+	}
+
+	address, detail := resolver(instance)
+
+	return address, detail, nil
+}
+
+// getForcedConnection returns the connection chosen by --ssm, --pub or --priv, with the flag.
+func getForcedConnection(flags *pflag.FlagSet) (string, string) {
+	for _, forced := range forcedConnections {
+		if value, _ := flags.GetBool(forced.Flag); value {
+			return forced.Conn, forced.Flag
+		}
+	}
+
+	return "", ""
+}
+
+// ResolveTarget chooses the address to connect to, either forced by a flag or the first available in ConnectionOrder.
+func ResolveTarget(flags *pflag.FlagSet, instance *inst.Instance) (*Resolution, error) {
+	if conn, flag := getForcedConnection(flags); conn != "" {
+		address, detail, _ := resolveAddress(conn, instance)
+
+		if address == "" {
+			return nil, fmt.Errorf("%w for %s, --%s needs a %s address", ErrNoTarget, instance.InstanceId, flag, conn)
+		}
+
+		return &Resolution{Conn: conn, Target: address, Reason: fmt.Sprintf("%s, forced by --%s", detail, flag)}, nil
+	}
+
+	conns, err := config.GetConnectionOrder()
+
+	if err != nil {
+		return nil, err
+	}
+
+	skipped := []string{}
+
+	for _, conn := range conns {
+		address, detail, err := resolveAddress(conn, instance)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if address != "" {
+			return &Resolution{Conn: conn, Target: address, Reason: fmt.Sprintf("%s, first available in ConnectionOrder", detail), Skipped: skipped}, nil
+		}
+
+		skipped = append(skipped, conn)
+	}
+
+	return nil, fmt.Errorf("%w for %s, none of ConnectionOrder [%s] is available", ErrNoTarget, instance.InstanceId, strings.Join(conns, ", "))
+}
+
+// bracketIPv6 wraps IPv6 addresses in brackets, where a host is followed by a port or path.
+func bracketIPv6(host string) string {
+	if strings.Contains(host, ":") {
+		return fmt.Sprintf("[%s]", host)
+	}
+
+	return host
+}
+
+// IsPrivateConnection reports whether the connection is only reachable from within the VPC.
+func IsPrivateConnection(conn string) bool {
+	return conn == "PRIVATE" || conn == "PRIVATE_DNS"
+}
+
+func (r *Resolution) String() string {
+	explanation := fmt.Sprintf("%s chosen by %s: %s", r.Target, r.Conn, r.Reason)
+
+	if len(r.Skipped) > 0 {
+		explanation += fmt.Sprintf(" (unavailable: %s)", strings.Join(r.Skipped, ", "))
+	}
+
+	return explanation
+}
+
+// explainConnection prints which rules chose the address and the login user, for --dryRun.
+func explainConnection(flags *pflag.FlagSet, instance *inst.Instance) {
+	if resolution, err := ResolveTarget(flags, instance); err == nil {
+		fmt.Fprintf(os.Stderr, "Target %s\n", resolution)
+	}
+
+	if user, reason, err := resolveLoginName(flags, instance); err == nil {
+		fmt.Fprintf(os.Stderr, "User %s from %s\n", user, reason)
+	}
+}
@@ -0,0 +1,85 @@
+package ssh
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/spf13/viper"
+)
+
+func TestResolveTarget(t *testing.T) {
+	multihomed := &inst.Instance{
+		InstanceId:     "i-multi",
+		PrivateDnsName: "ip-10-0-0-5.ec2.internal",
+		NetworkInterfaces: []inst.NetworkInterface{
+			{NetworkInterfaceId: "eni-secondary", DeviceIndex: 1, PrivateIpAddresses: []string{"10.0.1.5"}, PublicIpAddresses: []string{"3.4.5.6"}, ElasticIpAddresses: []string{"3.4.5.6"}},
+			{NetworkInterfaceId: "eni-primary", PrivateIpAddresses: []string{"10.0.0.5"}, Ipv6Addresses: []string{"2600:1f18::10"}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		order   []string
+		args    []string
+		conn    string
+		target  string
+		reason  string
+		skipped []string
+	}{
+		{"public on secondary interface", []string{"PUBLIC", "PRIVATE"}, nil, "PUBLIC", "3.4.5.6", "public IP on secondary interface eni-secondary", []string{}},
+		{"private falls back to primary interface", []string{"PRIVATE"}, nil, "PRIVATE", "10.0.0.5", "private IP on primary interface eni-primary", []string{}},
+		{"skips unavailable", []string{"PUBLIC_DNS", "SSM", "IPV6"}, nil, "IPV6", "2600:1f18::10", "IPv6 address on primary interface eni-primary", []string{"PUBLIC_DNS", "SSM"}},
+		{"elastic ip", []string{"ELASTIC_IP"}, nil, "ELASTIC_IP", "3.4.5.6", "Elastic IP on secondary interface eni-secondary", []string{}},
+		{"private dns", []string{"PRIVATE_DNS"}, nil, "PRIVATE_DNS", "ip-10-0-0-5.ec2.internal", "private DNS name", []string{}},
+		{"forced", []string{"PUBLIC"}, []string{"--priv"}, "PRIVATE", "10.0.0.5", "forced by --priv", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfig(t)
+			viper.Set("ConnectionOrder", tt.order)
+
+			resolution, err := ResolveTarget(newFlags(t, tt.args...), multihomed)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resolution.Conn != tt.conn || resolution.Target != tt.target {
+				t.Errorf("ResolveTarget() = %s %s, expected %s %s", resolution.Conn, resolution.Target, tt.conn, tt.target)
+			}
+
+			if !strings.Contains(resolution.Reason, tt.reason) {
+				t.Errorf("ResolveTarget() reason %q, expected it to mention %q", resolution.Reason, tt.reason)
+			}
+
+			if !reflect.DeepEqual(resolution.Skipped, tt.skipped) {
+				t.Errorf("ResolveTarget() skipped %v, expected %v", resolution.Skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestResolveTargetUnsupported(t *testing.T) {
+	setupConfig(t)
+	viper.Set("ConnectionOrder", []string{"PUBLIC", "CARRIER"})
+
+	_, err := ResolveTarget(newFlags(t), &inst.Instance{InstanceId: "i-1"})
+
+	if err == nil || errors.Is(err, ErrNoTarget) || !strings.Contains(err.Error(), "CARRIER") {
+		t.Errorf("ResolveTarget() error = %v, expected the unsupported connection to be named", err)
+	}
+}
+
+func TestResolutionString(t *testing.T) {
+	resolution := &Resolution{Conn: "PRIVATE", Target: "10.0.0.5", Reason: "primary private IP, first available in ConnectionOrder", Skipped: []string{"PUBLIC"}}
+
+	expected := "10.0.0.5 chosen by PRIVATE: primary private IP, first available in ConnectionOrder (unavailable: PUBLIC)"
+
+	if actual := resolution.String(); actual != expected {
+		t.Errorf("String() = %q, expected %q", actual, expected)
+	}
+}
@@ -0,0 +1,371 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/spf13/pflag"
+)
+
+const (
+	ForwardLocal   = "-L"
+	ForwardRemote  = "-R"
+	ForwardDynamic = "-D"
+)
+
+const defaultBindHost = "127.0.0.1"
+
+// Forward is a single ssh port forward. Bind is the listening [address:]port,
+// on the local side for -L and -D and on the instance for -R, Destination is unset for -D.
+type Forward struct {
+	Type        string
+	Bind        string
+	Destination string
+}
+
+// Arg renders the forward as given to ssh after its flag.
+func (f *Forward) Arg() string {
+	if f.Destination == "" {
+		return f.Bind
+	}
+
+	return fmt.Sprintf("%s:%s", f.Bind, f.Destination)
+}
+
+func (f *Forward) String() string {
+	switch f.Type {
+	case ForwardDynamic:
+		return fmt.Sprintf("SOCKS proxy on %s", f.Bind)
+	case ForwardRemote:
+		return fmt.Sprintf("%s on the instance to %s", f.Bind, f.Destination)
+	}
+
+	return fmt.Sprintf("%s to %s", f.Bind, f.Destination)
+}
+
+// Args returns the tunnel flags that rebuild this forward, keeping a picked free port on reconnects.
+func (f *Forward) Args() []string {
+	switch f.Type {
+	case ForwardDynamic:
+		return []string{"--dynamic", "--local", f.Bind}
+	case ForwardRemote:
+		return []string{"--reverse", "--remote", f.Bind, "--local", f.Destination}
+	}
+
+	return []string{"--local", f.Bind, "--remote", f.Destination}
+}
+
+// splitAddress splits [host:]port, where the host may be missing.
+func splitAddress(value string) (string, string, error) {
+	address := value
+
+	if !strings.Contains(address, ":") {
+		address = ":" + address
+	}
+
+	host, port, err := net.SplitHostPort(address)
+
+	if err != nil || port == "" {
+		return "", "", usageError("Address must be in form [host:]port: [%s] failed.", value)
+	}
+
+	return host, port, nil
+}
+
+// getFreePort asks the OS for an unused local port.
+func getFreePort() (string, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(defaultBindHost, "0"))
+
+	if err != nil {
+		return "", err
+	}
+
+	defer listener.Close()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+
+	return port, err
+}
+
+// normalizeBind defaults the host to the loopback address and replaces port 0 with a free port.
+func normalizeBind(value string, defaultPort string) (string, error) {
+	if value == "" {
+		value = defaultPort
+	}
+
+	host, port, err := splitAddress(value)
+
+	if err != nil {
+		return "", err
+	}
+
+	if host == "" {
+		host = defaultBindHost
+	}
+
+	if port == "0" {
+		if port, err = getFreePort(); err != nil {
+			return "", err
+		}
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// normalizeDestination requires a port, defaulting the host to localhost.
+func normalizeDestination(value string, flag string) (string, error) {
+	if value == "" {
+		return "", usageError("--%s is required", flag)
+	}
+
+	host, port, err := splitAddress(value)
+
+	if err != nil {
+		return "", err
+	}
+
+	if host == "" {
+		host = "localhost"
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// NewForward builds a forward from --local, --remote, --reverse and --dynamic.
+func NewForward(flags *pflag.FlagSet) (*Forward, error) {
+	local, _ := flags.GetString("local")
+	remote, _ := flags.GetString("remote")
+	reverse, _ := flags.GetBool("reverse")
+	dynamic, _ := flags.GetBool("dynamic")
+
+	return ParseForward(local, remote, reverse, dynamic)
+}
+
+// ParseForward builds a forward where local is always the endpoint on this machine and remote the one reached through the instance.
+func ParseForward(local string, remote string, reverse bool, dynamic bool) (*Forward, error) {
+	if reverse && dynamic {
+		return nil, usageError("Please specify only one of the following flags: --reverse, --dynamic")
+	}
+
+	if dynamic {
+		if remote != "" {
+			return nil, usageError("--remote can't be used with --dynamic, destinations are chosen by the SOCKS client")
+		}
+
+		bind, err := normalizeBind(local, "1080")
+
+		return &Forward{Type: ForwardDynamic, Bind: bind}, err
+	}
+
+	if reverse {
+		if remote == "" {
+			return nil, usageError("--remote is required")
+		}
+
+		// The instance's sshd decides where a remote bind listens, so only the given address is passed on
+		destination, err := normalizeDestination(local, "local")
+
+		return &Forward{Type: ForwardRemote, Bind: remote, Destination: destination}, err
+	}
+
+	destination, err := normalizeDestination(remote, "remote")
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, port, _ := splitAddress(destination)
+
+	bind, err := normalizeBind(local, port)
+
+	return &Forward{Type: ForwardLocal, Bind: bind, Destination: destination}, err
+}
+
+// UseSSMForwarding reports whether the forward runs as an SSM port forwarding session instead of through ssh,
+// which only supports local forwards but needs no key.
+func UseSSMForwarding(flags *pflag.FlagSet, instance *inst.Instance, forward *Forward) (bool, error) {
+	if forward.Type != ForwardLocal {
+		return false, nil
+	}
+
+	conn, _, err := getConnection(flags, instance)
+
+	return conn == "SSM", err
+}
+
+// ssmListeningMarker is the line session-manager-plugin prints once the local port listens.
+const ssmListeningMarker = "Waiting for connections"
+
+// listenerPollInterval is how often the bind address is checked while waiting for ssh to listen on it.
+var listenerPollInterval = 100 * time.Millisecond
+
+// bindInUse reports whether something already listens on the address.
+func bindInUse(address string) bool {
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return true
+	}
+
+	listener.Close()
+
+	return false
+}
+
+// checkBindFree fails when another process already listens on the address, which ssh would only report once connected.
+func checkBindFree(address string) error {
+	if bindInUse(address) {
+		return usageError("%s is already in use", address)
+	}
+
+	return nil
+}
+
+// announceListener reports the address once ssh listens on it, until done is closed. ssh only logs its forwards
+// listening in verbose mode, which floods the terminal, so the address is checked instead.
+func announceListener(address string, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(listenerPollInterval):
+		}
+
+		if bindInUse(address) {
+			fmt.Fprintf(stderrWriter, "Listening on %s\n", address)
+
+			return
+		}
+	}
+}
+
+// listenerWatcher passes output through, announcing the bound address once a line reports it listening.
+type listenerWatcher struct {
+	out     io.Writer
+	address string
+	marker  string
+	buf     []byte
+}
+
+func (w *listenerWatcher) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+
+		if idx < 0 {
+			break
+		}
+
+		w.writeLine(w.buf[:idx+1])
+		w.buf = w.buf[idx+1:]
+	}
+
+	return len(p), nil
+}
+
+func (w *listenerWatcher) writeLine(line []byte) {
+	if w.marker != "" && bytes.Contains(line, []byte(w.marker)) {
+		fmt.Fprintf(stderrWriter, "Listening on %s\n", w.address)
+
+		w.marker = ""
+	}
+
+	w.out.Write(line)
+}
+
+// Flush writes any trailing output that didn't end in a newline.
+func (w *listenerWatcher) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(w.buf)
+		w.buf = nil
+	}
+}
+
+// runTunnel runs ssh in the foreground, announcing the local address once ssh listens on it.
+func runTunnel(cmd *exec.Cmd, forward *Forward) error {
+	if forward.Type != ForwardRemote {
+		done := make(chan struct{})
+		defer close(done)
+
+		go announceListener(forward.Bind, done)
+	}
+
+	return runCommand(cmd)
+}
+
+// Tunnel keeps an ssh connection open in the foreground, only forwarding ports.
+func Tunnel(flags *pflag.FlagSet, instance *inst.Instance, key string, forward *Forward) error {
+	if forward.Type != ForwardRemote {
+		if err := checkBindFree(forward.Bind); err != nil {
+			return err
+		}
+	}
+
+	base, components, err := generateCmd(flags, instance, key, "-N", "-o", "ExitOnForwardFailure=yes", forward.Type, forward.Arg())
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Forwarding %s\n", forward)
+
+	if dryRun, _ := flags.GetBool("dryRun"); dryRun {
+		explainConnection(flags, instance)
+
+		return nil
+	}
+
+	return runTunnel(exec.Command(base, components...), forward)
+}
+
+// TunnelSSM forwards a local port to a host reachable from the instance with an SSM port forwarding session.
+func TunnelSSM(flags *pflag.FlagSet, instance *inst.Instance, forward *Forward) error {
+	if !config.IsSSMPossible() {
+		return usageError("session-manager-plugin must be installed to forward ports via SSM")
+	}
+
+	if instance.SSMAgentVersion != "" && inst.CompareVersions(instance.SSMAgentVersion, inst.MinimumRemoteHostAgentVersion) < 0 {
+		return usageError("SSM agent %s on %s is older than %s, which is required to forward to remote hosts", instance.SSMAgentVersion, instance.InstanceId, inst.MinimumRemoteHostAgentVersion)
+	}
+
+	bindHost, localPort, _ := splitAddress(forward.Bind)
+
+	if bindHost != defaultBindHost && bindHost != "localhost" {
+		return usageError("SSM port forwarding only listens on localhost, not %s", bindHost)
+	}
+
+	if err := checkBindFree(forward.Bind); err != nil {
+		return err
+	}
+
+	host, port, _ := splitAddress(forward.Destination)
+
+	parameters := map[string]string{
+		"host":            host,
+		"portNumber":      port,
+		"localPortNumber": localPort,
+	}
+
+	log.Println(inst.RemoteHostForwardingDocument, instance.InstanceId, parameters)
+
+	fmt.Fprintf(os.Stderr, "Forwarding %s\n", forward)
+
+	if dryRun, _ := flags.GetBool("dryRun"); dryRun {
+		return nil
+	}
+
+	watcher := &listenerWatcher{out: os.Stdout, address: forward.Bind, marker: ssmListeningMarker}
+	defer watcher.Flush()
+
+	return runSessionManagerPlugin(instance.Profile, instance.Region, instance.InstanceId, inst.RemoteHostForwardingDocument, parameters, watcher)
+}
@@ -0,0 +1,186 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/spf13/pflag"
+)
+
+func newTunnelFlags(t *testing.T, args ...string) *pflag.FlagSet {
+	t.Helper()
+
+	flags := pflag.NewFlagSet("tunnel", pflag.ContinueOnError)
+
+	flags.String("local", "", "")
+	flags.String("remote", "", "")
+	flags.Bool("reverse", false, "")
+	flags.Bool("dynamic", false, "")
+
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	return flags
+}
+
+func TestNewForward(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected Forward
+	}{
+		{"local port", []string{"--local", "15432", "--remote", "db.internal:5432"}, Forward{ForwardLocal, "127.0.0.1:15432", "db.internal:5432"}},
+		{"local defaults to remote port", []string{"--remote", "db.internal:5432"}, Forward{ForwardLocal, "127.0.0.1:5432", "db.internal:5432"}},
+		{"local address", []string{"--local", "0.0.0.0:8080", "--remote", "80"}, Forward{ForwardLocal, "0.0.0.0:8080", "localhost:80"}},
+		{"reverse", []string{"--reverse", "--remote", "8080", "--local", "3000"}, Forward{ForwardRemote, "8080", "localhost:3000"}},
+		{"dynamic", []string{"--dynamic", "--local", "1081"}, Forward{ForwardDynamic, "127.0.0.1:1081", ""}},
+		{"dynamic default port", []string{"--dynamic"}, Forward{ForwardDynamic, "127.0.0.1:1080", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forward, err := NewForward(newTunnelFlags(t, tt.args...))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *forward != tt.expected {
+				t.Errorf("NewForward() = %+v, expected %+v", *forward, tt.expected)
+			}
+
+			rebuilt, err := NewForward(newTunnelFlags(t, forward.Args()...))
+
+			if err != nil || *rebuilt != *forward {
+				t.Errorf("NewForward(%v) = %+v, %v, expected %+v", forward.Args(), rebuilt, err, *forward)
+			}
+		})
+	}
+}
+
+func TestNewForwardErrors(t *testing.T) {
+	tests := [][]string{
+		{"--local", "5432"},
+		{"--reverse", "--dynamic"},
+		{"--reverse", "--local", "3000"},
+		{"--dynamic", "--remote", "db:5432"},
+		{"--remote", "db.internal:"},
+	}
+
+	for _, args := range tests {
+		if _, err := NewForward(newTunnelFlags(t, args...)); !errors.Is(err, ErrUsage) {
+			t.Errorf("NewForward(%v) expected ErrUsage, got %v", args, err)
+		}
+	}
+}
+
+func TestNewForwardFreePort(t *testing.T) {
+	forward, err := NewForward(newTunnelFlags(t, "--local", "0", "--remote", "db:5432"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.HasSuffix(forward.Bind, ":0") {
+		t.Errorf("expected port 0 to be replaced with a free port, got %s", forward.Bind)
+	}
+}
+
+func TestGenerateCmdExtraBeforeTarget(t *testing.T) {
+	setupConfig(t)
+
+	instance := &inst.Instance{InstanceId: "i-0123", PrivateIpAddress: "10.0.0.1"}
+	forward := &Forward{Type: ForwardLocal, Bind: "127.0.0.1:5432", Destination: "db:5432"}
+
+	_, components, err := generateCmd(newFlags(t), instance, "key.pem", "-N", forward.Type, forward.Arg())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "-i key.pem -p 22 -l ec2-user -N -L 127.0.0.1:5432:db:5432 10.0.0.1"
+
+	if actual := strings.Join(components, " "); actual != expected {
+		t.Errorf("generateCmd() = %q, expected %q", actual, expected)
+	}
+}
+
+func TestListenerWatcher(t *testing.T) {
+	announced := captureOutput(t)
+
+	var out bytes.Buffer
+
+	watcher := &listenerWatcher{out: &out, address: "127.0.0.1:8080", marker: ssmListeningMarker}
+
+	watcher.Write([]byte("Starting session with SessionId: awssh-0123\nPort 8080 opened for sessionId awssh-0123.\n"))
+	watcher.Write([]byte("Waiting for connections...\n\nConnection accepted"))
+	watcher.Flush()
+
+	if expected := "Starting session with SessionId: awssh-0123\nPort 8080 opened for sessionId awssh-0123.\nWaiting for connections...\n\nConnection accepted"; out.String() != expected {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	if announced.String() != "Listening on 127.0.0.1:8080\n" {
+		t.Errorf("expected a single announcement, got %q", announced.String())
+	}
+}
+
+func TestAnnounceListener(t *testing.T) {
+	announced := captureOutput(t)
+
+	interval := listenerPollInterval
+	listenerPollInterval = time.Millisecond
+
+	t.Cleanup(func() { listenerPollInterval = interval })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	announceListener(listener.Addr().String(), make(chan struct{}))
+
+	if expected := fmt.Sprintf("Listening on %s\n", listener.Addr()); announced.String() != expected {
+		t.Errorf("expected %q, got %q", expected, announced.String())
+	}
+
+	announced.Reset()
+
+	done := make(chan struct{})
+
+	time.AfterFunc(20*time.Millisecond, func() { close(done) })
+
+	announceListener("127.0.0.1:0", done)
+
+	if announced.Len() != 0 {
+		t.Errorf("expected nothing announced for a free address, got %q", announced.String())
+	}
+}
+
+func TestCheckBindFree(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	if err := checkBindFree(listener.Addr().String()); !errors.Is(err, ErrUsage) {
+		t.Errorf("expected ErrUsage for a port in use, got %v", err)
+	}
+
+	if err := checkBindFree("127.0.0.1:0"); err != nil {
+		t.Errorf("expected a free port to pass, got %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	"github.com/gofrs/flock"
+	"github.com/spf13/pflag"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+	startupGrace      = 2 * time.Second
+)
+
+type TunnelStatus struct {
+	Name  string
+	Pid   int
+	Alive bool
+}
+
+// GetTunnelsDir holds a pid file, a lock and a log for every named tunnel started in the background.
+func GetTunnelsDir() string {
+	cachepath := GetCachePath()
+
+	return filepath.Join(cachepath.Dir, "tunnels")
+}
+
+func GetTunnelLogPath(name string) string {
+	return filepath.Join(GetTunnelsDir(), name+".log")
+}
+
+func getTunnelPidPath(name string) string {
+	return filepath.Join(GetTunnelsDir(), name+".pid")
+}
+
+// lockTunnel is held by the tunnel's process for as long as it runs. The OS releases it however the process ends,
+// so unlike the pid, which may since belong to another process, it reliably tells whether the tunnel is running.
+func lockTunnel(name string) (*flock.Flock, error) {
+	if err := os.MkdirAll(GetTunnelsDir(), 0700); err != nil {
+		return nil, err
+	}
+
+	lock := flock.New(filepath.Join(GetTunnelsDir(), name+".lock"))
+
+	locked, err := lock.TryLock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !locked {
+		return nil, usageError("Tunnel %s is already running", name)
+	}
+
+	return lock, nil
+}
+
+// tunnelRunning reports whether a process holds the tunnel's lock.
+func tunnelRunning(name string) bool {
+	lock, err := lockTunnel(name)
+
+	if err != nil {
+		return errors.Is(err, ErrUsage)
+	}
+
+	lock.Unlock()
+
+	return false
+}
+
+func readTunnelPid(name string) (int, bool) {
+	data, err := ioutil.ReadFile(getTunnelPidPath(name))
+
+	if err != nil {
+		return 0, false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+
+	return pid, err == nil
+}
+
+func writeTunnelPid(name string, pid int) error {
+	if err := os.MkdirAll(GetTunnelsDir(), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(getTunnelPidPath(name), []byte(strconv.Itoa(pid)), 0600)
+}
+
+// removeTunnelPid only removes the pid file while it still belongs to pid, so a restarted tunnel keeps its own.
+func removeTunnelPid(name string, pid int) {
+	if current, found := readTunnelPid(name); found && current == pid {
+		os.Remove(getTunnelPidPath(name))
+	}
+}
+
+func GetTunnelStatus(name string) TunnelStatus {
+	pid, found := readTunnelPid(name)
+
+	return TunnelStatus{
+		Name:  name,
+		Pid:   pid,
+		Alive: found && tunnelRunning(name),
+	}
+}
+
+// GetStartedTunnels lists the names of tunnels with a pid file, including ones no longer configured.
+func GetStartedTunnels() []string {
+	names := []string{}
+
+	files, _ := filepath.Glob(filepath.Join(GetTunnelsDir(), "*.pid"))
+
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".pid"))
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// StartTunnel runs awssh with args as a detached process logging to the tunnel's log, which locks the tunnel and records its pid.
+func StartTunnel(name string, args []string) (int, error) {
+	if status := GetTunnelStatus(name); status.Alive {
+		return 0, usageError("Tunnel %s is already running with pid %d", name, status.Pid)
+	}
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(GetTunnelsDir(), 0700); err != nil {
+		return 0, err
+	}
+
+	logFile, err := os.OpenFile(GetTunnelLogPath(name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer logFile.Close()
+
+	cmd := exec.Command(executable, args...)
+
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	detach(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	pid := cmd.Process.Pid
+
+	exited := make(chan error, 1)
+
+	go func() { exited <- cmd.Wait() }()
+
+	// Bad flags or a missing instance end the process right away, anything later is retried by the tunnel itself
+	select {
+	case err := <-exited:
+		removeTunnelPid(name, pid)
+
+		return 0, fmt.Errorf("Tunnel %s exited on start (%v), see %s", name, err, GetTunnelLogPath(name))
+	case <-time.After(startupGrace):
+		return pid, nil
+	}
+}
+
+// StopTunnel stops a background tunnel, waiting briefly for it to exit.
+func StopTunnel(name string) error {
+	status := GetTunnelStatus(name)
+
+	if !status.Alive {
+		removeTunnelPid(name, status.Pid)
+
+		return usageError("Tunnel %s is not running", name)
+	}
+
+	if err := stopProcess(status.Pid); err != nil {
+		return err
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if !tunnelRunning(name) {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	removeTunnelPid(name, status.Pid)
+
+	return nil
+}
+
+func connectTunnel(flags *pflag.FlagSet, query string, forward *Forward) error {
+	instance, err := PromptInstance(flags, query)
+
+	if err != nil {
+		return err
+	}
+
+	useSSM, err := UseSSMForwarding(flags, instance, forward)
+
+	if err != nil {
+		return err
+	}
+
+	if useSSM {
+		return TunnelSSM(flags, instance, forward)
+	}
+
+	key, _ := flags.GetString("identityFile")
+
+	// Ephemeral keys expire within a minute, so they're pushed on every connection
+	if UseEIC(flags) {
+		if key, err = PushEICKey(flags, instance); err != nil {
+			return err
+		}
+	}
+
+	return Tunnel(flags, instance, key, forward)
+}
+
+// SuperviseTunnel keeps the tunnel to the instance matching query up, reconnecting with a growing delay whenever it exits,
+// until awssh is asked to stop.
+func SuperviseTunnel(flags *pflag.FlagSet, name string, query string, forward *Forward) error {
+	lock, err := lockTunnel(name)
+
+	if err != nil {
+		return err
+	}
+
+	defer lock.Unlock()
+
+	if err := writeTunnelPid(name, os.Getpid()); err != nil {
+		return err
+	}
+
+	defer removeTunnelPid(name, os.Getpid())
+
+	stop := make(chan os.Signal, 1)
+
+	signal.Notify(stop, stopSignals...)
+	defer signal.Stop(stop)
+
+	delay := minReconnectDelay
+
+	for {
+		started := time.Now()
+
+		err := connectTunnel(flags, query, forward)
+
+		log.Printf("Tunnel %s exited: %v", name, err)
+
+		// A tunnel that stayed up for a while starts over with a short delay
+		if time.Since(started) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		log.Printf("Reconnecting in %s", delay)
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// PrintTunnels writes a table of the configured tunnels and any others still holding a pid file.
+func PrintTunnels(out io.Writer, tunnels map[string]config.TunnelConfig) error {
+	names := GetStartedTunnels()
+	started := map[string]bool{}
+
+	for _, name := range names {
+		started[name] = true
+	}
+
+	for name := range tunnels {
+		if !started[name] {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(writer, "NAME\tSTATUS\tFORWARD\tBASTION")
+
+	for _, name := range names {
+		status := GetTunnelStatus(name)
+
+		state := "stopped"
+
+		if status.Alive {
+			state = fmt.Sprintf("running (pid %d)", status.Pid)
+		}
+
+		forward, bastion := "-", "-"
+
+		if tunnel, found := tunnels[name]; found {
+			forward = fmt.Sprintf("%s to %s", tunnel.Local, tunnel.Remote)
+			bastion = tunnel.Bastion
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", name, state, forward, bastion)
+	}
+
+	return writer.Flush()
+}
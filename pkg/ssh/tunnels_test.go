@@ -0,0 +1,130 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+)
+
+func TestTunnelPidFiles(t *testing.T) {
+	setupConfig(t)
+
+	if status := GetTunnelStatus("db"); status.Pid != 0 || status.Alive {
+		t.Fatalf("GetTunnelStatus() = %+v before start", status)
+	}
+
+	pid := os.Getpid()
+
+	if err := writeTunnelPid("db", pid); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pid is of a live process, but not one running the tunnel
+	if status := GetTunnelStatus("db"); status.Pid != pid || status.Alive {
+		t.Errorf("GetTunnelStatus() = %+v, expected pid %d without the lock to be stopped", status, pid)
+	}
+
+	lock, err := lockTunnel("db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := GetTunnelStatus("db"); status.Pid != pid || !status.Alive {
+		t.Errorf("GetTunnelStatus() = %+v, expected pid %d alive", status, pid)
+	}
+
+	if _, err := lockTunnel("db"); !errors.Is(err, ErrUsage) {
+		t.Errorf("lockTunnel() expected ErrUsage while the tunnel runs, got %v", err)
+	}
+
+	lock.Unlock()
+
+	if status := GetTunnelStatus("db"); status.Alive {
+		t.Errorf("GetTunnelStatus() = %+v, expected stopped once the lock is released", status)
+	}
+
+	// A newer tunnel's pid file is left alone
+	removeTunnelPid("db", pid+1)
+
+	if _, found := readTunnelPid("db"); !found {
+		t.Error("removeTunnelPid() removed a pid file it doesn't own")
+	}
+
+	removeTunnelPid("db", pid)
+
+	if _, found := readTunnelPid("db"); found {
+		t.Error("removeTunnelPid() left its own pid file")
+	}
+}
+
+func TestStopTunnelNotRunning(t *testing.T) {
+	setupConfig(t)
+
+	if err := StopTunnel("db"); err == nil {
+		t.Error("StopTunnel() expected an error for a tunnel that isn't running")
+	}
+
+	// A stale pid file whose pid was reused, here by the test itself, must not get that process stopped
+	if err := writeTunnelPid("db", os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := StopTunnel("db"); !errors.Is(err, ErrUsage) {
+		t.Errorf("StopTunnel() expected ErrUsage for a reused pid, got %v", err)
+	}
+
+	if _, found := readTunnelPid("db"); found {
+		t.Error("StopTunnel() left the stale pid file")
+	}
+}
+
+func TestPrintTunnels(t *testing.T) {
+	setupConfig(t)
+
+	// No process gets this pid, leaving a stale pid file for a tunnel no longer configured
+	if err := writeTunnelPid("old", 1<<22+1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeTunnelPid("db", os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := lockTunnel("db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lock.Unlock()
+
+	tunnels := map[string]config.TunnelConfig{
+		"db":    {Bastion: "bastion-*", Local: "5432", Remote: "db.internal:5432"},
+		"cache": {Bastion: "i-123", Local: "6379", Remote: "cache.internal:6379"},
+	}
+
+	out := &bytes.Buffer{}
+
+	if err := PrintTunnels(out, tunnels); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	if len(lines) != 4 {
+		t.Fatalf("PrintTunnels() printed %d lines, expected 4:\n%s", len(lines), out)
+	}
+
+	expected := []string{"cache  stopped", "db     running", "old    stopped"}
+
+	for i, prefix := range expected {
+		if !strings.HasPrefix(lines[i+1], prefix) {
+			t.Errorf("PrintTunnels() line %q, expected prefix %q", lines[i+1], prefix)
+		}
+	}
+}
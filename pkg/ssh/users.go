@@ -0,0 +1,196 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/utils"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultUserRules cover the default users of common AMIs, after any configured rules.
+var defaultUserRules = []config.UserRule{
+	{Image: "ubuntu/*", User: "ubuntu"},
+	{Owner: "099720109477", User: "ubuntu"},
+	{Image: "debian-*", User: "admin"},
+	{Owner: "136693071363", User: "admin"},
+	{Image: "centos*", User: "centos"},
+	{Owner: "125523088429", User: "centos"},
+	{Image: "fedora*", User: "fedora"},
+	{Image: "rocky-*", User: "rocky"},
+	{Image: "rhel-*", User: "ec2-user"},
+	{Owner: "309956199498", User: "ec2-user"},
+	{Image: "bottlerocket-*", User: "ec2-user"},
+	{Image: "suse-*", User: "ec2-user"},
+	{Image: "amzn*", User: "ec2-user"},
+	{Owner: "amazon", User: "ec2-user"},
+}
+
+// ImageCache keeps AMI details on disk, keyed by region and image ID. AMIs don't change, so entries never expire.
+type ImageCache struct {
+	path   string
+	mu     sync.Mutex
+	Images map[string]*inst.Image
+}
+
+func GetImageCachePath() string {
+	cachepath := GetCachePath()
+
+	return filepath.Join(cachepath.Dir, "images.yaml")
+}
+
+func NewImageCache(path string) *ImageCache {
+	cache := &ImageCache{
+		path:   path,
+		Images: map[string]*inst.Image{},
+	}
+
+	if !readCacheFile(path, &cache.Images) || cache.Images == nil {
+		cache.Images = map[string]*inst.Image{}
+	}
+
+	return cache
+}
+
+func getImageKey(region string, id string) string {
+	return fmt.Sprintf("%s/%s", region, id)
+}
+
+// Lookup returns the image of an instance, describing it once. A missing AMI is cached as an image without a name.
+func (c *ImageCache) Lookup(clients inst.ClientProvider, instance *inst.Instance) (*inst.Image, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := getImageKey(instance.Region, instance.ImageId)
+
+	if image, found := c.Images[key]; found {
+		return image, nil
+	}
+
+	scope, err := inst.NewScope(instance.Profile, instance.Region)
+
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := inst.DescribeImage(clients.EC2(scope), instance.ImageId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if image == nil {
+		image = &inst.Image{ImageId: instance.ImageId}
+	}
+
+	c.Images[key] = image
+
+	return image, c.write()
+}
+
+func (c *ImageCache) write() error {
+	data, err := yaml.Marshal(c.Images)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(c.path, data, 0644)
+}
+
+// The image cache is shared by every lookup in the process, exec resolves users concurrently
+var (
+	imageCacheOnce sync.Once
+	imageCache     *ImageCache
+)
+
+func getImageCache() *ImageCache {
+	imageCacheOnce.Do(func() {
+		imageCache = NewImageCache(GetImageCachePath())
+	})
+
+	return imageCache
+}
+
+// matchPattern matches case insensitively, as AMI names are inconsistently cased.
+func matchPattern(pattern string, value string) bool {
+	matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+
+	return matched
+}
+
+func matchUserRule(rule config.UserRule, image *inst.Image) bool {
+	if rule.Image == "" && rule.Owner == "" {
+		return true
+	}
+
+	if rule.Image != "" && !matchPattern(rule.Image, image.Name) {
+		return false
+	}
+
+	if rule.Owner != "" && !matchPattern(rule.Owner, image.OwnerId) && !matchPattern(rule.Owner, image.OwnerAlias) {
+		return false
+	}
+
+	return true
+}
+
+// inferLoginName finds the user from the instance's tag or its AMI, returning an empty user when neither tells.
+func inferLoginName(clients inst.ClientProvider, cache *ImageCache, instance *inst.Instance) (string, string, error) {
+	if tag := config.GetUserTag(); tag != "" && instance.Tags[tag] != "" {
+		return instance.Tags[tag], fmt.Sprintf("tag %s", tag), nil
+	}
+
+	if instance.ImageId == "" {
+		return "", "", nil
+	}
+
+	rules, err := config.GetUserRules()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	image, err := cache.Lookup(clients, instance)
+
+	// Images may not be describable with the credentials at hand, which only leaves the default user
+	if err != nil || image.Name == "" {
+		return "", "", nil
+	}
+
+	for _, rule := range append(rules, defaultUserRules...) {
+		if matchUserRule(rule, image) {
+			return rule.User, fmt.Sprintf("AMI %s (%s)", image.ImageId, image.Name), nil
+		}
+	}
+
+	return "", "", nil
+}
+
+// resolveLoginName returns the login user and where it came from: --loginName, the user tag, the AMI or DefaultUser.
+func resolveLoginName(flags *pflag.FlagSet, instance *inst.Instance) (string, string, error) {
+	if loginName, _ := flags.GetString("loginName"); loginName != "" {
+		return loginName, "--loginName", nil
+	}
+
+	user, reason, err := inferLoginName(inst.DefaultClients, getImageCache(), instance)
+
+	if err != nil || user != "" {
+		return user, reason, err
+	}
+
+	user, err = config.GetDefaultUser()
+
+	return user, "DefaultUser", err
+}
@@ -0,0 +1,89 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/JFenstermacher/awssh/pkg/config"
+	inst "github.com/JFenstermacher/awssh/pkg/instances"
+	"github.com/JFenstermacher/awssh/pkg/instances/fake"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/viper"
+)
+
+func newImage(id string, name string, owner string) *ec2.Image {
+	return &ec2.Image{ImageId: aws.String(id), Name: aws.String(name), OwnerId: aws.String(owner)}
+}
+
+func TestInferLoginName(t *testing.T) {
+	client := &fake.EC2{
+		Images: []*ec2.Image{
+			newImage("ami-ubuntu", "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-20230516", "099720109477"),
+			newImage("ami-debian", "debian-12-amd64-20230711-1438", "136693071363"),
+			newImage("ami-rhel", "RHEL-9.2.0_HVM-20230503-x86_64-41-Hourly2-GP2", "309956199498"),
+			newImage("ami-custom", "golden-base-2023", "111122223333"),
+			newImage("ami-unknown", "some-appliance", "444455556666"),
+		},
+	}
+
+	provider := &fake.Provider{EC2Clients: map[string]*fake.EC2{"us-east-1": client}}
+
+	tests := []struct {
+		name     string
+		instance inst.Instance
+		expected string
+	}{
+		{"ubuntu", inst.Instance{ImageId: "ami-ubuntu"}, "ubuntu"},
+		{"debian", inst.Instance{ImageId: "ami-debian"}, "admin"},
+		{"rhel", inst.Instance{ImageId: "ami-rhel"}, "ec2-user"},
+		{"configured rule", inst.Instance{ImageId: "ami-custom"}, "deploy"},
+		{"tag wins over the AMI", inst.Instance{ImageId: "ami-ubuntu", Tags: map[string]string{"login": "ops"}}, "ops"},
+		{"unknown AMI", inst.Instance{ImageId: "ami-unknown"}, ""},
+		{"deregistered AMI", inst.Instance{ImageId: "ami-gone"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfig(t)
+
+			viper.Set("UserTag", "login")
+			viper.Set("UserRules", []config.UserRule{{Image: "golden-*", Owner: "111122223333", User: "deploy"}})
+
+			tt.instance.Region = "us-east-1"
+
+			user, _, err := inferLoginName(provider, NewImageCache(GetImageCachePath()), &tt.instance)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if user != tt.expected {
+				t.Errorf("inferLoginName() = %q, expected %q", user, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImageCacheLookup(t *testing.T) {
+	setupConfig(t)
+
+	client := &fake.EC2{Images: []*ec2.Image{newImage("ami-1", "debian-12", "136693071363")}}
+	provider := &fake.Provider{EC2Clients: map[string]*fake.EC2{"us-east-1": client}}
+
+	instance := &inst.Instance{ImageId: "ami-1", Region: "us-east-1"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := NewImageCache(GetImageCachePath()).Lookup(provider, instance); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(client.ImageInputs) != 1 {
+		t.Errorf("DescribeImages called %d times, expected the second lookup to be read from disk", len(client.ImageInputs))
+	}
+
+	// The same image ID in another region is a different AMI
+	if image, _ := NewImageCache(GetImageCachePath()).Lookup(provider, &inst.Instance{ImageId: "ami-1", Region: "eu-west-1"}); image.Name != "" {
+		t.Errorf("Lookup() = %+v, expected no image in eu-west-1", image)
+	}
+}
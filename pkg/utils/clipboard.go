@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+var clipboardCommands = [][]string{
+	{"pbcopy"},
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+	{"clip.exe"},
+}
+
+// CopyToClipboard pipes text into the first clipboard utility found on the PATH.
+func CopyToClipboard(text string) error {
+	for _, command := range clipboardCommands {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+
+		return cmd.Run()
+	}
+
+	return errors.New("No clipboard utility found")
+}
@@ -1,11 +1,12 @@
 package utils
 
 import (
-	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"io/ioutil"
 )
 
+// HashFile returns the hex SHA-256 of the file.
 func HashFile(filename string) (string, error) {
 	data, err := ioutil.ReadFile(filename)
 
@@ -13,7 +14,7 @@ func HashFile(filename string) (string, error) {
 		return "", err
 	}
 
-	hash := md5.New()
+	hash := sha256.New()
 
 	hash.Write(data)
 
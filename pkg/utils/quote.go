@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var safeShellWord = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// ShellQuote quotes a word so sh reads it back unchanged.
+func ShellQuote(word string) string {
+	if safeShellWord.MatchString(word) {
+		return word
+	}
+
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+func ShellJoin(words []string) string {
+	quoted := []string{}
+
+	for _, word := range words {
+		quoted = append(quoted, ShellQuote(word))
+	}
+
+	return strings.Join(quoted, " ")
+}